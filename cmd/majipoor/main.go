@@ -10,6 +10,8 @@ import (
 	"github.com/spf13/viper"
 	"majipoor/cmd/majipoor/gen"
 	"majipoor/cmd/majipoor/mysql"
+	"majipoor/cmd/majipoor/psql"
+	"majipoor/cmd/majipoor/snapshot"
 	"majipoor/lib/helpers"
 	"os"
 	"strings"
@@ -101,10 +103,11 @@ func main() {
 	rootCmd.PersistentFlags().StringArray("mysql-skip-tables", []string{}, "Skip these tables when syncing")
 	rootCmd.PersistentFlags().String("mysql-root-username", "root", "Mysql root username")
 	rootCmd.PersistentFlags().String("mysql-root-password", "master", "Mysql root password")
+	rootCmd.PersistentFlags().Uint32("mysql-server-id", 100, "Server ID of the binlog stream, unique among the replicas of the server")
 	if err := viperBindNestedPFlags("mysql", &rootCmd,
 		[]string{"mysql-host", "mysql-username", "mysql-password", "mysql-port", "mysql-db",
 			"mysql-limit-tables", "mysql-skip-tables",
-			"mysql-root-username", "mysql-root-password"}); err != nil {
+			"mysql-root-username", "mysql-root-password", "mysql-server-id"}); err != nil {
 		log.Fatal().Err(err).Msg("Could not bind persistent flags")
 	}
 
@@ -114,8 +117,21 @@ func main() {
 	rootCmd.PersistentFlags().Int("postgresql-port", 5432, "PG port")
 	rootCmd.PersistentFlags().String("postgresql-db", "postgres", "PG database")
 	rootCmd.PersistentFlags().String("postgresql-schema", "majipoor", "PG destination schema")
+	rootCmd.PersistentFlags().String("postgresql-metadata-schema", "majipoor_metadata", "PG schema for majipoor's own bookkeeping")
+	rootCmd.PersistentFlags().String("postgresql-sslmode", "disable", "PG sslmode")
+	rootCmd.PersistentFlags().String("postgresql-type-mapping", "loose", "Mysql to PG type mapping (loose, strict)")
+	rootCmd.PersistentFlags().String("postgresql-keyless-strategy", "full-row", "How to apply changes to tables without primary key (full-row, row-hash, append-only)")
+	rootCmd.PersistentFlags().String("postgresql-table-mode", "mirror", "What tables keep of the past rows (mirror, soft-delete, history)")
+	rootCmd.PersistentFlags().StringArray("postgresql-table-modes", []string{}, "Mode of a table, as table=mode")
+	rootCmd.PersistentFlags().Bool("postgresql-metadata-columns", false, "Add the replication metadata columns (_majipoor_op, _majipoor_gtid, _majipoor_commit_ts, _majipoor_synced_at) to every table")
+	rootCmd.PersistentFlags().Bool("postgresql-indexes", true, "Recreate the secondary mysql indexes in PG")
+	rootCmd.PersistentFlags().String("postgresql-root-username", "postgres", "PG root username")
+	rootCmd.PersistentFlags().String("postgresql-root-password", "master", "PG root password")
 	if err := viperBindNestedPFlags("postgresql", &rootCmd,
-		[]string{"postgresql-host", "postgresql-username", "postgresql-password", "postgresql-port", "postgresql-db", "postgresql-schema"}); err != nil {
+		[]string{"postgresql-host", "postgresql-username", "postgresql-password", "postgresql-port", "postgresql-db", "postgresql-schema",
+			"postgresql-metadata-schema", "postgresql-sslmode", "postgresql-type-mapping", "postgresql-keyless-strategy",
+			"postgresql-table-mode", "postgresql-table-modes", "postgresql-metadata-columns",
+			"postgresql-indexes", "postgresql-root-username", "postgresql-root-password"}); err != nil {
 		log.Fatal().Err(err).Msg("Could not bind persistent flags")
 	}
 
@@ -125,6 +141,8 @@ func main() {
 
 	rootCmd.AddCommand(mysql.MysqlCmd)
 	rootCmd.AddCommand(gen.GenCmd)
+	rootCmd.AddCommand(psql.PsqlCmd)
+	rootCmd.AddCommand(snapshot.SnapshotCmd)
 
 	helpers.StartSIGPROFStacktraceDumper("")
 
@@ -2,48 +2,272 @@ package mysql
 
 import (
 	"context"
-	"github.com/go-mysql-org/go-mysql/mysql"
-	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"majipoor/lib/binlog"
+	"majipoor/lib/helpers"
+	mysql2 "majipoor/lib/mysql"
+	"majipoor/lib/psql"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+func connectPsql() *psql.PsqlDB {
+	psqlConnectionString := helpers.GetPsqlConnectionString()
+	log.Debug().Str("psql-connection-string", psqlConnectionString).Msg("Connecting to postgresql")
+	pd, err := psql.NewPsqlDB(psqlConnectionString)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not connect to postgresql")
+	}
+	return pd
+}
+
+// getLastSnapshotPosition returns the binlog position recorded by the last snapshot
+func getLastSnapshotPosition(pd *psql.PsqlDB) binlog.Position {
+	snapshot, err := pd.GetLastSnapshot(viper.GetString("postgresql.metadata-schema"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not get last snapshot")
+	}
+	if snapshot == nil {
+		log.Fatal().Msg("No snapshot found")
+	}
+	log.Info().Int64("snapshot", snapshot.ID).Str("gtid-set", snapshot.GtidSet).
+		Str("binlog-file", snapshot.BinlogFile).
+		Uint32("binlog-position", snapshot.BinlogPosition).
+		Time("created-at", snapshot.CreatedAt).Msg("Resuming from snapshot")
+
+	return binlog.Position{
+		GTIDSet: snapshot.GtidSet,
+		File:    snapshot.BinlogFile,
+		Pos:     snapshot.BinlogPosition,
+	}
+}
+
+// getCheckpoint returns the checkpoint of the last applied batch, or nil if there is none
+func getCheckpoint(pd *psql.PsqlDB) *psql.Checkpoint {
+	checkpoint, err := pd.GetCheckpoint(viper.GetString("postgresql.metadata-schema"), psql.DefaultCheckpointName)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not get checkpoint")
+	}
+	return checkpoint
+}
+
+// writeHeartbeats writes a heartbeat every interval until ctx is done. The heartbeat table is
+// in the replicated database, which the replica user has all privileges on.
+func writeHeartbeats(ctx context.Context, heartbeat *binlog.Heartbeat, serverID uint32, interval time.Duration) {
+	db, err := mysql2.NewMysqlDB(helpers.GetReplicaMysqlConnectionString())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not connect to database to write heartbeats")
+	}
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+
+	if err = db.CreateHeartbeatTable(heartbeat.Database, heartbeat.Table); err != nil {
+		log.Fatal().Err(err).Msg("Could not create heartbeat table")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err = db.WriteHeartbeat(heartbeat.Database, heartbeat.Table, serverID); err != nil {
+			// the binlog stream reports the lag growing
+			log.Warn().Err(err).Msg("Could not write heartbeat")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 var binlogCmd = &cobra.Command{
 	Use:   "binlog",
 	Short: "Subscribe to mysql binlog",
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg := replication.BinlogSyncerConfig{
-			ServerID: 100,
-			Flavor:   "mysql",
-			Host:     viper.GetString("mysql.host"),
-			Port:     uint16(viper.GetInt("mysql.port")),
-			User:     viper.GetString("mysql.username"),
-			Password: viper.GetString("mysql.password"),
+		gtid, _ := cmd.Flags().GetString("gtid")
+		binlogFile, _ := cmd.Flags().GetString("binlog-file")
+		binlogPosition, _ := cmd.Flags().GetUint32("binlog-position")
+		fromSnapshot, _ := cmd.Flags().GetBool("from-snapshot")
+		apply, _ := cmd.Flags().GetBool("apply")
+		flushInterval, _ := cmd.Flags().GetDuration("flush-interval")
+		heartbeat, _ := cmd.Flags().GetDuration("heartbeat")
+		reconnectBackoff, _ := cmd.Flags().GetDuration("reconnect-backoff")
+		maxReconnectBackoff, _ := cmd.Flags().GetDuration("max-reconnect-backoff")
+		writeHeartbeat, _ := cmd.Flags().GetDuration("write-heartbeat")
+		serverID := viper.GetUint32("mysql.server-id")
+		if serverID == 0 {
+			log.Fatal().Msg("The server ID must not be 0")
 		}
 
-		syncer := replication.NewBinlogSyncer(cfg)
-		gtidSet, err := mysql.ParseGTIDSet("mysql", "")
+		var pd *psql.PsqlDB
+		if apply || fromSnapshot {
+			pd = connectPsql()
+			defer func() {
+				err := pd.Close()
+				if err != nil {
+					log.Error().Err(err).Msg("Could not close postgresql connection")
+				}
+			}()
+		}
+		if pd != nil {
+			err := pd.CreateMetadataTables(viper.GetString("postgresql.metadata-schema"))
+			if err != nil {
+				log.Fatal().Err(err).Msg("Could not create metadata tables")
+			}
+		}
+		// without GTID set, the stream starts from a binlog file and position
+		start := binlog.Position{GTIDSet: gtid}
+		if binlogFile != "" {
+			if gtid != "" {
+				log.Fatal().Msg("--gtid and --binlog-file are exclusive")
+			}
+			start = binlog.Position{File: binlogFile, Pos: binlogPosition}
+		}
+		if fromSnapshot {
+			start = getLastSnapshotPosition(pd)
+		}
+		// changes already loaded by a resumed snapshot are applied again as upserts, and the
+		// changes of the tables loaded by a partial snapshot are skipped up to its position
+		var upsertUntil *binlog.Position
+		var tablePositions map[string]binlog.Position
+		if apply {
+			checkpoint := getCheckpoint(pd)
+			if checkpoint != nil {
+				upsertUntil = checkpoint.UpsertUntil()
+			}
+			if !cmd.Flags().Changed("gtid") && binlogFile == "" && !fromSnapshot {
+				if checkpoint == nil {
+					log.Fatal().Msg("No checkpoint found, run a snapshot first or pass --gtid or --binlog-file")
+				}
+				log.Info().Str("gtid-set", checkpoint.GtidSet).
+					Str("binlog-file", checkpoint.BinlogFile).
+					Uint32("binlog-position", checkpoint.BinlogPosition).
+					Time("updated-at", checkpoint.UpdatedAt).
+					Msg("Resuming from checkpoint")
+				start = binlog.Position{
+					GTIDSet: checkpoint.GtidSet,
+					File:    checkpoint.BinlogFile,
+					Pos:     checkpoint.BinlogPosition,
+				}
+			}
+			if upsertUntil != nil {
+				log.Info().Str("position", upsertUntil.String()).
+					Msg("Applying inserts as upserts up to the position of the resumed snapshot")
+			}
+			var err error
+			tablePositions, err = pd.GetTablePositions(viper.GetString("postgresql.metadata-schema"),
+				psql.DefaultCheckpointName)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Could not get table positions")
+			}
+			for table, position := range tablePositions {
+				log.Info().Str("table", table).Str("position", position.String()).
+					Msg("Skipping the changes of the table up to the position it was loaded at")
+			}
+		}
+
+		connectionString := helpers.GetReplicaMysqlConnectionString()
+		log.Debug().Str("mysql-connection-string", connectionString).Msg("Connecting to mysql")
+		db, err := mysql2.NewMysqlDB(connectionString)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Could not parse gtid set")
+			log.Fatal().Err(err).Msg("Could not connect to database")
 		}
-		streamer, err := syncer.StartSyncGTID(gtidSet)
+
+		defer func() {
+			err := db.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close database connection")
+			}
+		}()
+
+		version, err := db.GetServerVersion()
 		if err != nil {
-			log.Fatal().Err(err).Msg("Could not start binlog sync")
+			log.Fatal().Err(err).Msg("Could not get server version")
+		}
+		log.Info().Str("flavor", version.Flavor).Str("version", version.Version).Msg("Connected to mysql")
+
+		pipeline := newPipeline(cmd, pd, db, version.Flavor, start, upsertUntil, tablePositions)
+
+		if start.GTIDSet == "" && start.File != "" {
+			log.Warn().Str("binlog-file", start.File).Uint32("binlog-position", start.Pos).
+				Msg("Replicating without GTIDs, the position is only valid on this server")
+		}
+		streamer := binlog.NewStreamer(binlog.StreamerSettings{
+			Flavor:          version.Flavor,
+			Host:            viper.GetString("mysql.host"),
+			Port:            uint16(viper.GetInt("mysql.port")),
+			User:            viper.GetString("mysql.username"),
+			Password:        viper.GetString("mysql.password"),
+			ServerID:        serverID,
+			HeartbeatPeriod: heartbeat,
+			MinBackoff:      reconnectBackoff,
+			MaxBackoff:      maxReconnectBackoff,
+		}, start)
+		defer streamer.Close()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if writeHeartbeat > 0 {
+			go writeHeartbeats(ctx, pipeline.heartbeats, serverID, writeHeartbeat)
 		}
 
 		for {
-			ev, err := streamer.GetEvent(context.Background())
+			pipeline.report()
+			if ctx.Err() != nil {
+				pipeline.flush()
+				log.Info().Str("position", pipeline.decoder.Position().String()).Msg("Stopped binlog streaming")
+				return
+			}
+
+			eventCtx, cancel := context.WithTimeout(ctx, flushInterval)
+			ev, err := streamer.GetEvent(eventCtx)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) {
+				pipeline.flush()
+				continue
+			}
+			if errors.Is(err, context.Canceled) {
+				continue
+			}
+			if err == binlog.ErrRestarted {
+				// the transaction in progress is streamed again
+				pipeline.discard()
+				continue
+			}
+			var purged *binlog.PurgedError
+			if errors.As(err, &purged) {
+				log.Fatal().Err(err).Msg("The binlog position is not available anymore, snapshot the tables again")
+			}
 			if err != nil {
-				log.Warn().Err(err).Send()
+				log.Fatal().Err(err).Msg("Could not get binlog event")
+			}
+
+			pipeline.handle(ev)
+			if pipeline.decoder.IsCommit() {
+				streamer.SetPosition(pipeline.decoder.Position())
 			}
-			// Dump event
-			ev.Dump(os.Stdout)
 		}
 	},
 }
 
 func init() {
+	binlogCmd.Flags().String("gtid", "", "Start after this executed GTID set (defaults to the checkpoint when applying)")
+	binlogCmd.Flags().String("binlog-file", "", "Start from this binlog file, on servers without GTIDs")
+	binlogCmd.Flags().Uint32("binlog-position", binlog.MinPosition, "Start from this position in --binlog-file")
+	binlogCmd.Flags().Bool("from-snapshot", false, "Start after the position of the last snapshot")
+	addPipelineFlags(binlogCmd)
+	binlogCmd.Flags().Duration("heartbeat", 30*time.Second, "Heartbeat period of the server, the connection is restarted after 3 periods without events")
+	binlogCmd.Flags().Duration("reconnect-backoff", time.Second, "Delay before reconnecting after an error, doubled after each failure")
+	binlogCmd.Flags().Duration("max-reconnect-backoff", time.Minute, "Maximum delay before reconnecting")
+	binlogCmd.Flags().Duration("write-heartbeat", 0, "Write a heartbeat to mysql at this interval, to measure the lag of idle databases (0 disables)")
 	MysqlCmd.AddCommand(binlogCmd)
 }
@@ -56,9 +56,21 @@ var checkConfigCmd = &cobra.Command{
 			log.Info().Interface("slave-status", slaveStatus).Send()
 		}
 
-		if config.LogBin == "ON" && config.BinlogFormat == "ROW" && config.BinlogRowImage == "FULL" && config.GtidMode == "ON" {
+		switch config.ReplicationMode() {
+		case mysql.ReplicationGTID:
 			log.Info().Msg("Replica possible")
-		} else {
+		case mysql.ReplicationFilePosition:
+			log.Warn().Msg("Replica possible without GTIDs (degraded)")
+			fmt.Println(`gtid_mode is not ON, replication will follow binlog file names and positions.
+
+This works, but the checkpoint is only valid on this server: it is lost on a failover to another
+server, and when the binlog files are renumbered (RESET MASTER). Start streaming with
+--from-snapshot or --binlog-file, and enable GTIDs when possible:
+
+[mysqld]
+gtid_mode=ON
+enforce-gtid-consistency=ON`)
+		default:
 			log.Error().Msg("Replica not possible")
 			fmt.Println(`Please add the following entries to your my.cnf file (or binlog.cnf under /etc/mysql/mysql.conf.d/
 to enable binary logging:
@@ -68,17 +80,16 @@ binlog_format= ROW
 binlog_row_image=FULL
 log-bin = mysql-bin
 server-id = 1
-expire_logs_days = 10
-gtid_mode=ON
+expire_logs_days = 10`)
+			// mariadb always has GTIDs
+			if config.Flavor == mysql.MySQLFlavor {
+				fmt.Println(`gtid_mode=ON
 enforce-gtid-consistency=ON`)
+			}
 		}
 	},
 }
 
-// TODO(manuel) On postgresql side
-// CREATE USER usr_replica WITH PASSWORD 'replica';
-// CREATE DATABASE db_replica WITH OWNER usr_replica;
-
 // TODO(manuel) Build a tool to generate full schemas and test data, so that we can test replication against a real setup
 // - this should generate schemas, fake data for the schemas, inserts, updates, deletes
 // - it should also generate DDL statements (alter, drop, etc...)
@@ -90,8 +101,6 @@ enforce-gtid-consistency=ON`)
 
 // TODO(manuel) Run the schema dump against the ttc database and see which types we get
 
-// TODO(manuel) Gather which indexes to create when inspecting the schema
-
 // TODO create a test framework using a docker test DB to test binlog streaming
 
 func init() {
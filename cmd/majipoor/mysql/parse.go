@@ -9,10 +9,10 @@ import (
 
 var parseCmd = &cobra.Command{
 	Use:   "parse",
-	Short: "Parse a MySQL statement",
+	Short: "Parse a MySQL CREATE TABLE or ALTER TABLE statement",
 	Run: func(cmd *cobra.Command, args []string) {
 		for _, v := range args {
-			sql, err := grammar.Parse(v)
+			sql, err := grammar.ParseStatement(v)
 			if err != nil {
 				log.Warn().Err(err).Str("sql", v).Msg("failed to parse")
 				continue
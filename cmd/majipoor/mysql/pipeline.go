@@ -0,0 +1,219 @@
+package mysql
+
+import (
+	"encoding/json"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"majipoor/lib/binlog"
+	"majipoor/lib/helpers"
+	mysql2 "majipoor/lib/mysql"
+	"majipoor/lib/psql"
+	"os"
+	"time"
+)
+
+const skipReportInterval = time.Minute
+
+func logSkipped(skipped binlog.SkipCounts) {
+	log.Info().Int64("other-database-events", skipped.OtherDatabaseEvents).
+		Int64("other-database-rows", skipped.OtherDatabaseRows).
+		Int64("filtered-table-events", skipped.FilteredTableEvents).
+		Int64("filtered-table-rows", skipped.FilteredTableRows).
+		Msg("Skipped row events")
+}
+
+// pipeline filters and decodes binlog events, and applies the row changes to postgresql or
+// writes them out. It is shared by the binlog stream and the replay of binlog files.
+type pipeline struct {
+	filter     *binlog.Filter
+	decoder    *binlog.Decoder
+	applier    *psql.Applier
+	heartbeats *binlog.Heartbeat
+	format     string
+	encoder    *json.Encoder
+
+	lastReport time.Time
+	reported   binlog.SkipCounts
+}
+
+func addPipelineFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("apply", false, "Apply row changes to postgresql instead of dumping events")
+	cmd.Flags().Int("batch-size", 1000, "Number of row changes applied per postgresql transaction with --coalesce")
+	cmd.Flags().Bool("coalesce", false, "Apply several mysql transactions per postgresql transaction, without ever splitting one")
+	cmd.Flags().Duration("flush-interval", time.Second, "Maximum time before pending row changes are applied")
+	cmd.Flags().Bool("upsert", false, "Apply inserts as upserts on tables with a primary key")
+	cmd.Flags().String("heartbeat-table", mysql2.DefaultHeartbeatTable, "Table of the mysql database heartbeats are written to")
+	cmd.Flags().String("format", "dump", "Output format when not applying: dump (raw events) or json (one row change per line)")
+}
+
+// newPipeline returns a pipeline for the events of a server of flavor from start, with the
+// settings of the flags of addPipelineFlags. pd is required to apply. Inserts are upserts
+// until upsertUntil if it is set, and the changes of the tables of tablePositions are skipped
+// up to their position, see psql.ApplierSettings.
+func newPipeline(cmd *cobra.Command, pd *psql.PsqlDB, source binlog.SchemaSource, flavor string, start binlog.Position,
+	upsertUntil *binlog.Position, tablePositions map[string]binlog.Position) *pipeline {
+	apply, _ := cmd.Flags().GetBool("apply")
+	batchSize, _ := cmd.Flags().GetInt("batch-size")
+	coalesce, _ := cmd.Flags().GetBool("coalesce")
+	flushInterval, _ := cmd.Flags().GetDuration("flush-interval")
+	upsert, _ := cmd.Flags().GetBool("upsert")
+	heartbeatTable, _ := cmd.Flags().GetString("heartbeat-table")
+	// only the replay has a checkpoint of its own, the stream uses the default one
+	checkpointName, _ := cmd.Flags().GetString("checkpoint")
+	format, _ := cmd.Flags().GetString("format")
+	if format != "dump" && format != "json" {
+		log.Fatal().Str("format", format).Msg("Unknown output format")
+	}
+
+	database := viper.GetString("mysql.database")
+	p := &pipeline{
+		filter: binlog.NewFilter(database, viper.GetStringSlice("mysql.limit-tables"),
+			viper.GetStringSlice("mysql.skip-tables")),
+		// heartbeats are streamed whatever the table filters, and never applied
+		heartbeats: &binlog.Heartbeat{Database: database, Table: heartbeatTable},
+		format:     format,
+		encoder:    json.NewEncoder(os.Stdout),
+		lastReport: time.Now(),
+	}
+	p.filter.Include(p.heartbeats.Database, p.heartbeats.Table)
+
+	// row events carry no column names, they are looked up in the schema
+	var err error
+	p.decoder, err = binlog.NewDecoder(source, flavor, start)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create decoder")
+	}
+	// dumped events are not decoded, but the position is still tracked
+	p.decoder.SkipRows = !apply && format == "dump"
+	if pd != nil {
+		// decode with the columns recorded at the position of the events
+		p.decoder.History = psql.NewSchemaHistory(pd, viper.GetString("postgresql.metadata-schema"))
+	}
+
+	if apply {
+		mapping, err := helpers.GetMappingSettings()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not parse mapping settings")
+		}
+
+		p.applier = psql.NewApplier(pd, psql.ApplierSettings{
+			Schema:         viper.GetString("postgresql.schema"),
+			Mapping:        mapping,
+			BatchSize:      batchSize,
+			Coalesce:       coalesce,
+			FlushInterval:  flushInterval,
+			Upsert:         upsert,
+			UpsertUntil:    upsertUntil,
+			TablePositions: tablePositions,
+			MetadataSchema: viper.GetString("postgresql.metadata-schema"),
+			CheckpointName: checkpointName,
+		})
+	}
+	return p
+}
+
+// report logs the skipped events and the lag periodically.
+func (p *pipeline) report() {
+	if time.Since(p.lastReport) <= skipReportInterval {
+		return
+	}
+	if skipped := p.filter.Skipped(); skipped != p.reported {
+		logSkipped(skipped)
+		p.reported = skipped
+	}
+	if p.applier != nil {
+		log.Info().Dur("lag", p.applier.Lag()).Msg("Replication lag")
+	}
+	p.lastReport = time.Now()
+}
+
+// flush applies the committed changes.
+func (p *pipeline) flush() {
+	if p.applier == nil {
+		return
+	}
+	if err := p.applier.Flush(); err != nil {
+		log.Fatal().Err(err).Msg("Could not apply changes")
+	}
+}
+
+// discard drops the changes of the transaction in progress.
+func (p *pipeline) discard() {
+	if p.applier != nil {
+		p.applier.Discard()
+	} else if p.format == "json" {
+		log.Warn().Msg("Row changes of the last uncommitted transaction will be written again")
+	}
+}
+
+func (p *pipeline) handle(ev *replication.BinlogEvent) {
+	if !p.filter.Allows(ev) {
+		return
+	}
+
+	changes, err := p.decoder.Decode(ev)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not decode event")
+	}
+
+	if p.format == "dump" && p.applier == nil {
+		ev.Dump(os.Stdout)
+		return
+	}
+
+	for _, change := range p.decoder.SchemaChanges() {
+		if change.IsEmpty() || p.heartbeats.Matches(change.Database, change.Table) ||
+			!p.filter.AllowsTable(change.Database, change.Table) {
+			continue
+		}
+		logger := log.With().Str("table", change.Table).Str("gtid", change.GTID).
+			Str("statement", change.Statement).Logger()
+		if change.Unsupported != "" {
+			if p.applier == nil {
+				logger.Warn().Str("reason", change.Unsupported).Msg("Unsupported schema change")
+				continue
+			}
+			// applying the following row changes to the old schema would corrupt the table
+			logger.Fatal().Str("reason", change.Unsupported).
+				Msg("Schema change can't be applied to postgresql, change the schema manually and resnapshot the table")
+		}
+		if p.applier == nil {
+			logger.Info().Bool("created", change.Created).Int("added-columns", len(change.AddedColumns)).
+				Msg("Schema change")
+			continue
+		}
+		if err = p.applier.ApplySchemaChange(change, p.decoder.Position()); err != nil {
+			logger.Fatal().Err(err).Msg("Could not apply schema change")
+		}
+	}
+
+	rows := changes[:0]
+	for _, change := range changes {
+		if t, ok := p.heartbeats.Time(change); ok {
+			if p.applier != nil {
+				p.applier.Heartbeat(t)
+			}
+		} else if !p.heartbeats.Matches(change.Database, change.Table) {
+			rows = append(rows, change)
+		}
+	}
+	changes = rows
+
+	if p.applier == nil {
+		for _, change := range changes {
+			if err = p.encoder.Encode(change.Record()); err != nil {
+				log.Fatal().Err(err).Msg("Could not write change")
+			}
+		}
+		return
+	}
+
+	p.applier.Add(changes...)
+	if p.decoder.IsCommit() {
+		if err = p.applier.Commit(p.decoder.Position(), p.decoder.CommitTime()); err != nil {
+			log.Fatal().Err(err).Msg("Could not apply changes")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package mysql
+
+import (
+	"context"
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"majipoor/lib/binlog"
+	"majipoor/lib/helpers"
+	mysql2 "majipoor/lib/mysql"
+	"majipoor/lib/psql"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// replayDatetimeFormat is the format of mysqlbinlog --start-datetime, in UTC
+const replayDatetimeFormat = "2006-01-02 15:04:05"
+
+// offlineSchema is the schema source of a replay without server. Only the tables created in
+// the replayed files or recorded in the schema history can be decoded.
+type offlineSchema struct{}
+
+func (offlineSchema) GetColumnMetadata(database string, table string) ([]*mysql2.ColumnMetadata, error) {
+	return nil, errors.Errorf("No schema for %s.%s, pass --schema-history or --mysql-schema", database, table)
+}
+
+func parseReplayDatetime(cmd *cobra.Command, flag string) time.Time {
+	value, _ := cmd.Flags().GetString(flag)
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(replayDatetimeFormat, value, time.UTC)
+	if err != nil {
+		log.Fatal().Err(err).Str(flag, value).Msg("Could not parse datetime")
+	}
+	return t
+}
+
+func parseReplayGTIDs(cmd *cobra.Command, flag string, flavor string) gomysql.GTIDSet {
+	value, _ := cmd.Flags().GetString(flag)
+	if value == "" {
+		return nil
+	}
+	set, err := gomysql.ParseGTIDSet(flavor, value)
+	if err != nil {
+		log.Fatal().Err(err).Str(flag, value).Msg("Could not parse GTID set")
+	}
+	return set
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <files...>",
+	Short: "Replay local binlog files",
+	Long: `Replay binlog files (mysql-bin.*) from disk, in the given order, through the same
+decoding, filtering and apply or output as the binlog stream.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		apply, _ := cmd.Flags().GetBool("apply")
+		gtid, _ := cmd.Flags().GetString("gtid")
+		flavor, _ := cmd.Flags().GetString("flavor")
+		useMysqlSchema, _ := cmd.Flags().GetBool("mysql-schema")
+		useSchemaHistory, _ := cmd.Flags().GetBool("schema-history")
+		startPosition, _ := cmd.Flags().GetUint32("start-position")
+		stopPosition, _ := cmd.Flags().GetUint32("stop-position")
+		if flavor != mysql2.MySQLFlavor && flavor != mysql2.MariaDBFlavor {
+			log.Fatal().Str("flavor", flavor).Msg("Unknown flavor")
+		}
+
+		replayRange := &binlog.ReplayRange{
+			StartPosition: startPosition,
+			StopPosition:  stopPosition,
+			StartTime:     parseReplayDatetime(cmd, "start-datetime"),
+			StopTime:      parseReplayDatetime(cmd, "stop-datetime"),
+			IncludeGTIDs:  parseReplayGTIDs(cmd, "include-gtids", flavor),
+			ExcludeGTIDs:  parseReplayGTIDs(cmd, "exclude-gtids", flavor),
+		}
+
+		var pd *psql.PsqlDB
+		if apply || useSchemaHistory {
+			pd = connectPsql()
+			defer func() {
+				err := pd.Close()
+				if err != nil {
+					log.Error().Err(err).Msg("Could not close postgresql connection")
+				}
+			}()
+			err := pd.CreateMetadataTables(viper.GetString("postgresql.metadata-schema"))
+			if err != nil {
+				log.Fatal().Err(err).Msg("Could not create metadata tables")
+			}
+		}
+
+		var source binlog.SchemaSource = offlineSchema{}
+		if useMysqlSchema {
+			connectionString := helpers.GetReplicaMysqlConnectionString()
+			log.Debug().Str("mysql-connection-string", connectionString).Msg("Connecting to mysql")
+			db, err := mysql2.NewMysqlDB(connectionString)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Could not connect to database")
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					log.Error().Err(err).Msg("Could not close database connection")
+				}
+			}()
+			source = db
+		}
+
+		start := binlog.ReplayStart(args, replayRange, gtid)
+		pipeline := newPipeline(cmd, pd, source, flavor, start, nil, nil)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err := binlog.ReplayFiles(args, flavor, replayRange, func(ev *replication.BinlogEvent) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			pipeline.report()
+			pipeline.handle(ev)
+			return nil
+		})
+		if err != nil && errors.Cause(err) != context.Canceled {
+			log.Fatal().Err(err).Msg("Could not replay binlog files")
+		}
+
+		pipeline.flush()
+		log.Info().Str("position", pipeline.decoder.Position().String()).Msg("Replayed binlog files")
+	},
+}
+
+func init() {
+	addPipelineFlags(replayCmd)
+	replayCmd.Flags().String("gtid", "", "Executed GTID set before the first file, to keep track of GTIDs")
+	replayCmd.Flags().String("checkpoint", "replay", "Name of the checkpoint saved with --apply ("+psql.DefaultCheckpointName+" moves the one the binlog stream resumes from)")
+	replayCmd.Flags().String("flavor", mysql2.MySQLFlavor, "Flavor of the server that wrote the files (mysql, mariadb)")
+	replayCmd.Flags().Bool("mysql-schema", false, "Look up the columns of tables on the mysql server")
+	replayCmd.Flags().Bool("schema-history", false, "Look up the columns of tables in the schema versions recorded in postgresql")
+	replayCmd.Flags().Uint32("start-position", binlog.MinPosition, "Start from this position in the first file")
+	replayCmd.Flags().Uint32("stop-position", 0, "Stop before this position in the last file")
+	replayCmd.Flags().String("start-datetime", "", "Skip the transactions before this time (UTC, "+replayDatetimeFormat+")")
+	replayCmd.Flags().String("stop-datetime", "", "Stop at the first event at or after this time (UTC, "+replayDatetimeFormat+")")
+	replayCmd.Flags().String("include-gtids", "", "Replay only the transactions of this GTID set")
+	replayCmd.Flags().String("exclude-gtids", "", "Skip the transactions of this GTID set")
+	binlogCmd.AddCommand(replayCmd)
+}
@@ -34,14 +34,30 @@ var schemaCmd = &cobra.Command{
 			log.Fatal().Err(err).Msg("Could not get tables")
 		}
 		for _, table := range tables {
-			columns, err := db.GetTableMetadata(database, table)
+			columns, err := db.GetColumnMetadata(database, table)
+			if err != nil {
+				log.Fatal().Err(err).Str("table", table).Msg("Could not get column metadata")
+			}
+			metadata, err := db.GetTableMetadata(database, table)
 			if err != nil {
 				log.Fatal().Err(err).Str("table", table).Msg("Could not get table metadata")
 			}
-			log.Info().Str("table", table).Msg("Found table")
+			log.Info().Str("table", table).Interface("metadata", metadata).Msg("Found table")
+			if !metadata.IsTransactional() {
+				log.Warn().Str("table", table).Msg("Non transactional table, it can't take part in a consistent snapshot")
+			}
 			for _, c := range columns {
 				log.Info().Str("table", table).Str("column", c.ColumnName).Msg("Found column")
 			}
+			indexes, err := db.GetIndexes(database, table)
+			if err != nil {
+				log.Fatal().Err(err).Str("table", table).Msg("Could not get indexes")
+			}
+			for _, i := range indexes {
+				log.Info().Str("table", table).Str("index", i.Name).Str("type", i.Type).Bool("unique", i.Unique).
+					Strs("columns", i.Columns).Ints("prefixes", i.Prefixes).Bool("functional", i.Functional).
+					Msg("Found index")
+			}
 
 			stmt := mysql.GetSelectCSVSatement(table, columns)
 			fmt.Println(stmt)
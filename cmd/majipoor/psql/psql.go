@@ -1,8 +1,65 @@
+// Package psql contains commands to interact with the postgresql datalake
 package psql
 
-// drop schema
-// create schema
-// swap tables from tmp schema to real schema
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"majipoor/lib/helpers"
+	"majipoor/lib/psql"
+)
 
-//
-// batch events
+var PsqlCmd = &cobra.Command{
+	Use:   "psql",
+	Short: "postgresql related commands",
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Swap the previous generation of the tables back into the destination schema",
+	Long: `Swap the previous generation of the tables back into the destination schema, along with
+the replication position they were at when they were replaced.
+
+The binlog applier must be stopped during a rollback. Generations replaced without their
+position can't be rolled back, snapshot the tables again instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		psqlConnectionString := helpers.GetPsqlConnectionString()
+		log.Debug().Str("psql-connection-string", psqlConnectionString).Msg("Connecting to postgresql")
+		pd, err := psql.NewPsqlDB(psqlConnectionString)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not connect to postgresql")
+		}
+
+		defer func() {
+			err := pd.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close postgresql connection")
+			}
+		}()
+
+		metadataSchema := viper.GetString("postgresql.metadata-schema")
+		err = pd.CreateMetadataTables(metadataSchema)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create metadata tables")
+		}
+		err = pd.RollbackGeneration(viper.GetString("postgresql.schema"), metadataSchema)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not roll back")
+		}
+	},
+}
+
+func init() {
+	createSchemaCmd.Flags().Bool("dry-run", false, "Dry run")
+	createSchemaCmd.Flags().Bool("force", false, "Force recreation")
+	createSchemaCmd.Flags().String("constraints", "none", "What to do with the mysql foreign keys and check constraints (none, not-valid, comment)")
+	createSchemaCmd.Flags().Bool("constraints-only", false, "Only add the constraints to the existing tables, once they are loaded")
+
+	createReplicaUserCmd.Flags().Bool("dry-run", false, "Dry run")
+	createReplicaUserCmd.Flags().Bool("force", false, "Force recreation")
+
+	createReplicaDatabaseCmd.Flags().Bool("dry-run", false, "Dry run")
+	createReplicaDatabaseCmd.Flags().Bool("force", false, "Force recreation")
+
+	PsqlCmd.AddCommand(createSchemaCmd, rollbackCmd, createReplicaUserCmd, createReplicaDatabaseCmd)
+}
@@ -0,0 +1,113 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"majipoor/lib/helpers"
+	"majipoor/lib/mysql"
+	"majipoor/lib/psql"
+)
+
+var createSchemaCmd = &cobra.Command{
+	Use:   "create-schema",
+	Short: "Create the mysql tables in the postgresql schema",
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+		constraints, _ := cmd.Flags().GetString("constraints")
+		constraintsOnly, _ := cmd.Flags().GetBool("constraints-only")
+
+		mapping, err := helpers.GetMappingSettings()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not parse mapping settings")
+		}
+		constraintMode, err := psql.ParseConstraintMode(constraints)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not parse constraint mode")
+		}
+
+		connectionString := helpers.GetReplicaMysqlConnectionString()
+		log.Debug().Str("mysql-connection-string", connectionString).Msg("Connecting to mysql")
+		db, err := mysql.NewMysqlDB(connectionString)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not connect to database")
+		}
+
+		defer func() {
+			err := db.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close database connection")
+			}
+		}()
+
+		database := viper.GetString("mysql.database")
+		tableNames, err := db.GetTables(database, viper.GetStringSlice("mysql.limit-tables"),
+			viper.GetStringSlice("mysql.skip-tables"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not get tables")
+		}
+
+		var tables []*psql.Table
+		for _, tableName := range tableNames {
+			columns, err := db.GetColumnMetadata(database, tableName)
+			if err != nil {
+				log.Fatal().Err(err).Str("table", tableName).Msg("Could not get table metadata")
+			}
+			table, err := psql.MapTable(tableName, columns, mapping)
+			if err != nil {
+				log.Fatal().Err(err).Str("table", tableName).Msg("Could not map table")
+			}
+			if mapping.Indexes {
+				indexes, err := db.GetIndexes(database, tableName)
+				if err != nil {
+					log.Fatal().Err(err).Str("table", tableName).Msg("Could not get indexes")
+				}
+				table.MapIndexes(indexes)
+			}
+			if constraintMode != psql.ConstraintsNone {
+				tableConstraints, err := db.GetConstraints(database, tableName)
+				if err != nil {
+					log.Fatal().Err(err).Str("table", tableName).Msg("Could not get constraints")
+				}
+				table.MapConstraints(database, tableConstraints)
+			}
+			tables = append(tables, table)
+		}
+
+		settings := psql.CreateSchemaSettings{
+			Force:           force,
+			Schema:          viper.GetString("postgresql.schema"),
+			Tables:          tables,
+			Constraints:     constraintMode,
+			ConstraintsOnly: constraintsOnly,
+		}
+
+		if dryRun {
+			for _, stmt := range psql.GetCreateSchemaStatements(settings) {
+				fmt.Printf("%s;\n\n", stmt)
+			}
+			return
+		}
+
+		psqlConnectionString := helpers.GetPsqlConnectionString()
+		log.Debug().Str("psql-connection-string", psqlConnectionString).Msg("Connecting to postgresql")
+		pd, err := psql.NewPsqlDB(psqlConnectionString)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not connect to postgresql")
+		}
+
+		defer func() {
+			err := pd.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close postgresql connection")
+			}
+		}()
+
+		err = pd.CreateSchema(settings)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create schema")
+		}
+	},
+}
@@ -0,0 +1,78 @@
+package psql
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"majipoor/lib/helpers"
+	"majipoor/lib/psql"
+)
+
+func connectRootPsql(database string) *psql.PsqlDB {
+	connectionString := helpers.GetRootPsqlConnectionString(database)
+	log.Debug().Str("psql-connection-string", connectionString).Msg("Connecting to postgresql")
+	pd, err := psql.NewPsqlDB(connectionString)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not connect to postgresql")
+	}
+	return pd
+}
+
+var createReplicaUserCmd = &cobra.Command{
+	Use:   "create-replica-user",
+	Short: "Create the postgresql user majipoor replicates as",
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+
+		// schemas are per database, so connect to the replica database
+		pd := connectRootPsql(viper.GetString("postgresql.db"))
+		defer func() {
+			err := pd.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close postgresql connection")
+			}
+		}()
+
+		err := pd.CreateReplicaUser(psql.CreateReplicaUserSettings{
+			Force:          force,
+			DryRun:         dryRun,
+			RootUsername:   viper.GetString("postgresql.root-username"),
+			Database:       viper.GetString("postgresql.db"),
+			Schema:         viper.GetString("postgresql.schema"),
+			MetadataSchema: viper.GetString("postgresql.metadata-schema"),
+			Username:       viper.GetString("postgresql.username"),
+			Password:       viper.GetString("postgresql.password"),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create replica user")
+		}
+	},
+}
+
+var createReplicaDatabaseCmd = &cobra.Command{
+	Use:   "create-replica-database",
+	Short: "Create the postgresql database majipoor replicates into",
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+
+		// a database can't be dropped or created while connected to it
+		pd := connectRootPsql("postgres")
+		defer func() {
+			err := pd.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close postgresql connection")
+			}
+		}()
+
+		err := pd.CreateReplicaDatabase(psql.CreateReplicaDatabaseSettings{
+			Force:    force,
+			DryRun:   dryRun,
+			Database: viper.GetString("postgresql.db"),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create replica database")
+		}
+	},
+}
@@ -0,0 +1,118 @@
+package snapshot
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"majipoor/lib/helpers"
+	"majipoor/lib/mysql"
+	"majipoor/lib/psql"
+	"majipoor/lib/snapshot"
+	"time"
+)
+
+var SnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Load the content of the mysql tables into postgresql",
+	Long: `Load the content of the mysql tables into postgresql.
+
+The binlog applier must be stopped during a snapshot, it would overwrite the checkpoint the
+snapshot records. The checkpoint is reset to the position of the snapshot if it loads all the
+tables of the schema. When --mysql-limit-tables or --mysql-skip-tables leave some out, the
+loaded tables get the position of the snapshot as their own, and the applier skips their
+changes up to it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		mapping, err := helpers.GetMappingSettings()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not parse mapping settings")
+		}
+
+		connectionString := helpers.GetReplicaMysqlConnectionString()
+		log.Debug().Str("mysql-connection-string", connectionString).Msg("Connecting to mysql")
+		db, err := mysql.NewMysqlDB(connectionString)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not connect to database")
+		}
+
+		defer func() {
+			err := db.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close database connection")
+			}
+		}()
+
+		psqlConnectionString := helpers.GetPsqlConnectionString()
+		log.Debug().Str("psql-connection-string", psqlConnectionString).Msg("Connecting to postgresql")
+		pd, err := psql.NewPsqlDB(psqlConnectionString)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not connect to postgresql")
+		}
+
+		defer func() {
+			err := pd.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not close postgresql connection")
+			}
+		}()
+
+		database := viper.GetString("mysql.database")
+		tables, err := db.GetTables(database, viper.GetStringSlice("mysql.limit-tables"),
+			viper.GetStringSlice("mysql.skip-tables"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not get tables")
+		}
+
+		snapshotter := &snapshot.Snapshotter{
+			Mysql:          db,
+			Psql:           pd,
+			Database:       database,
+			Schema:         viper.GetString("postgresql.schema"),
+			MetadataSchema: viper.GetString("postgresql.metadata-schema"),
+			Mapping:        mapping,
+		}
+		snapshotter.ChunkSize, _ = cmd.Flags().GetInt("chunk-size")
+		snapshotter.Workers, _ = cmd.Flags().GetInt("workers")
+		snapshotter.Restart, _ = cmd.Flags().GetBool("restart")
+
+		inPlace, _ := cmd.Flags().GetBool("in-place")
+		stagingSchema, _ := cmd.Flags().GetString("staging-schema")
+		if stagingSchema == "" {
+			stagingSchema = psql.GetStagingSchemaName(snapshotter.Schema)
+		}
+		keepGenerations, _ := cmd.Flags().GetDuration("keep-generations")
+
+		start := time.Now()
+		var result *snapshot.SnapshotResult
+		if inPlace {
+			result, err = snapshotter.Snapshot(tables)
+		} else {
+			result, err = snapshotter.Reload(tables, snapshot.ReloadSettings{
+				StagingSchema:      stagingSchema,
+				KeepGenerationsFor: keepGenerations,
+			})
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not snapshot tables")
+		}
+
+		var totalRows int64
+		for _, t := range result.Tables {
+			totalRows += t.Rows
+		}
+		log.Info().Int("tables", len(result.Tables)).
+			Int64("rows", totalRows).
+			Str("gtid-set", result.MasterStatus.ExecutedGtidSet).
+			Bool("resumed", result.Resumed).
+			Dur("duration", time.Since(start)).
+			Msg("Snapshot done")
+	},
+}
+
+func init() {
+	SnapshotCmd.Flags().Bool("in-place", false, "Load each table directly into the destination schema instead of swapping in a staging schema")
+	SnapshotCmd.Flags().String("staging-schema", "", "Staging schema (default <postgresql-schema>__staging)")
+	SnapshotCmd.Flags().Int("chunk-size", 100000, "Number of rows loaded per chunk (0 to load each table in one go)")
+	SnapshotCmd.Flags().Int("workers", 4, "Number of chunks loaded in parallel")
+	SnapshotCmd.Flags().Bool("restart", false, "Discard the progress of an interrupted snapshot instead of resuming it")
+	SnapshotCmd.Flags().Duration("keep-generations", 24*time.Hour, "How long to keep replaced tables around for a rollback")
+}
@@ -0,0 +1,74 @@
+// Package binlog decodes mysql binlog events into row changes.
+package binlog
+
+import (
+	"majipoor/lib/mysql"
+	"time"
+)
+
+type Operation string
+
+const (
+	OperationInsert Operation = "insert"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+// RowChange is a single row inserted, updated or deleted in mysql.
+//
+// Before and After hold the row images in column order, Before is nil for inserts
+// and After is nil for deletes. Values are normalized: unsigned integers are unsigned,
+// enums and sets are strings, JSON is a string and binary strings are []byte.
+type RowChange struct {
+	Database  string
+	Table     string
+	Operation Operation
+	Columns   []*mysql.ColumnMetadata
+	Before    []interface{}
+	After     []interface{}
+	GTID      string
+	Timestamp time.Time
+}
+
+// Image returns the after image for inserts and updates, and the before image for deletes.
+func (r *RowChange) Image() []interface{} {
+	if r.Operation == OperationDelete {
+		return r.Before
+	}
+	return r.After
+}
+
+// ChangeRecord is the JSON representation of a RowChange, with the row images keyed by
+// column name. Binary values are base64 encoded, as encoding/json does for []byte.
+type ChangeRecord struct {
+	Database  string                 `json:"database"`
+	Table     string                 `json:"table"`
+	Operation Operation              `json:"operation"`
+	GTID      string                 `json:"gtid,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty"`
+}
+
+func (r *RowChange) imageMap(image []interface{}) map[string]interface{} {
+	if image == nil {
+		return nil
+	}
+	m := map[string]interface{}{}
+	for i, v := range image {
+		m[r.Columns[i].ColumnName] = v
+	}
+	return m
+}
+
+func (r *RowChange) Record() *ChangeRecord {
+	return &ChangeRecord{
+		Database:  r.Database,
+		Table:     r.Table,
+		Operation: r.Operation,
+		GTID:      r.GTID,
+		Timestamp: r.Timestamp,
+		Before:    r.imageMap(r.Before),
+		After:     r.imageMap(r.After),
+	}
+}
@@ -0,0 +1,67 @@
+package binlog
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+	"time"
+)
+
+func TestRecordJSON(t *testing.T) {
+	change := &RowChange{
+		Database:  "wordpress",
+		Table:     "wp_options",
+		Operation: OperationUpdate,
+		Columns: []*mysql.ColumnMetadata{
+			{ColumnName: "option_id"},
+			{ColumnName: "option_value"},
+		},
+		Before:    []interface{}{uint64(1), "old"},
+		After:     []interface{}{uint64(1), nil},
+		GTID:      "3e11fa47-71ca-11e1-9e33-c80aa9429562:23",
+		Timestamp: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	b, err := json.Marshal(change.Record())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"database": "wordpress",
+		"table": "wp_options",
+		"operation": "update",
+		"gtid": "3e11fa47-71ca-11e1-9e33-c80aa9429562:23",
+		"timestamp": "2022-05-01T12:00:00Z",
+		"before": {"option_id": 1, "option_value": "old"},
+		"after": {"option_id": 1, "option_value": null}
+	}`, string(b))
+
+	change.Operation = OperationDelete
+	change.After = nil
+	b, err = json.Marshal(change.Record())
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), `"after"`)
+}
+
+func TestRecordJSONText(t *testing.T) {
+	columns := []*mysql.ColumnMetadata{
+		{ColumnName: "ID", DataType: "bigint", ColumnType: "bigint(20) unsigned"},
+		{ColumnName: "post_content", DataType: "longtext", ColumnType: "longtext"},
+		{ColumnName: "post_hash", DataType: "varbinary", ColumnType: "varbinary(4)"},
+	}
+	// go-mysql decodes longtext as []byte, like the binary columns
+	row := normalizeRow(columns, []interface{}{int64(1), []byte("Hello world"), []byte{0xca, 0xfe}})
+	change := &RowChange{
+		Database:  "wordpress",
+		Table:     "wp_posts",
+		Operation: OperationInsert,
+		Columns:   columns,
+		After:     row,
+		Timestamp: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	b, err := json.Marshal(change.Record())
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"post_content":"Hello world"`)
+	assert.Contains(t, string(b), `"post_hash":"yv4="`)
+}
@@ -0,0 +1,323 @@
+package binlog
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
+	"majipoor/lib/mysql"
+	"majipoor/lib/mysql/grammar"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SchemaChange is a DDL statement of the binlog affecting a table.
+//
+// Only additive changes can be applied to postgresql: creating a table and adding columns.
+// Index changes, table options and the like don't change the replicated data and are
+// ignored. Everything else is Unsupported.
+type SchemaChange struct {
+	Database  string
+	Table     string
+	Statement string
+	GTID      string
+	Timestamp time.Time
+
+	// Created is true if the table was created
+	Created bool
+	// AddedColumns were added to the table by an ALTER TABLE
+	AddedColumns []*AddedColumn
+	// Columns are the columns of the table after the change
+	Columns []*mysql.ColumnMetadata
+
+	// Unsupported is why the change can't be applied to postgresql, if it can't
+	Unsupported string
+}
+
+// AddedColumn is a column added by an ALTER TABLE.
+type AddedColumn struct {
+	Column *mysql.ColumnMetadata
+	// Value is what the existing rows get in mysql, in its text form. nil is NULL.
+	Value *string
+}
+
+// IsEmpty returns true if the change has nothing to apply.
+func (c *SchemaChange) IsEmpty() bool {
+	return !c.Created && len(c.AddedColumns) == 0 && c.Unsupported == ""
+}
+
+var (
+	// leading comments are kept in the binlog (/* ApplicationName=... */ ALTER TABLE ...)
+	leadingCommentRegexp  = regexp.MustCompile(`^(\s|/\*.*?\*/|#[^\n]*\n|--[^\n]*\n)*`)
+	tableStatementRegexp  = regexp.MustCompile(`(?is)^(ALTER|CREATE|DROP|RENAME|TRUNCATE)\s+(TEMPORARY\s+)?(TABLE\s+)?(IF\s+(NOT\s+)?EXISTS\s+)?(.*)$`)
+	trailingCommentRegexp = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	renameTargetRegexp    = regexp.MustCompile(`(?is)\s+TO\s+.*$`)
+	tableNameRegexp       = regexp.MustCompile("^((`(?:[^`]|``)+`|[\\w$]+)(\\.(`(?:[^`]|``)+`|[\\w$]+))?)")
+)
+
+// splitTableName splits a possibly qualified and quoted table name.
+func splitTableName(defaultDatabase string, name string) (string, string) {
+	name = strings.TrimSpace(name)
+	database := defaultDatabase
+	if idx := strings.Index(name, "`.`"); idx >= 0 {
+		database, name = name[:idx+1], name[idx+2:]
+	} else if !strings.HasPrefix(name, "`") {
+		if idx := strings.Index(name, "."); idx >= 0 {
+			database, name = name[:idx], name[idx+1:]
+		}
+	}
+	unquote := func(s string) string {
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, "`") && strings.HasSuffix(s, "`") && len(s) > 1 {
+			s = strings.ReplaceAll(s[1:len(s)-1], "``", "`")
+		}
+		return s
+	}
+	return unquote(database), unquote(name)
+}
+
+// decodeSchemaChanges returns the changes of a DDL statement. Statements that don't affect
+// tables (CREATE USER, GRANT, CREATE DATABASE, ...) return nothing.
+func (d *Decoder) decodeSchemaChanges(header *replication.EventHeader, e *replication.QueryEvent) []*SchemaChange {
+	query := leadingCommentRegexp.ReplaceAllString(string(e.Query), "")
+	matches := tableStatementRegexp.FindStringSubmatch(query)
+	if matches == nil {
+		return nil
+	}
+	verb := strings.ToUpper(matches[1])
+	// temporary tables are not replicated, and TABLE is only optional for TRUNCATE
+	if matches[2] != "" || (matches[3] == "" && verb != "TRUNCATE") {
+		return nil
+	}
+
+	newChange := func(table string) *SchemaChange {
+		database, name := splitTableName(string(e.Schema), table)
+		return &SchemaChange{
+			Database:  database,
+			Table:     name,
+			Statement: string(e.Query),
+			GTID:      d.currentGTID,
+			Timestamp: time.Unix(int64(header.Timestamp), 0).UTC(),
+		}
+	}
+
+	switch verb {
+	case "DROP", "RENAME", "TRUNCATE":
+		// these can name several tables: DROP TABLE a, b and RENAME TABLE a TO b, c TO d
+		var changes []*SchemaChange
+		tables := trailingCommentRegexp.ReplaceAllString(matches[6], "")
+		for _, table := range strings.Split(tables, ",") {
+			if verb == "RENAME" {
+				table = renameTargetRegexp.ReplaceAllString(table, "")
+			}
+			change := newChange(table)
+			change.Unsupported = fmt.Sprintf("%s TABLE can't be applied to postgresql", verb)
+			d.forgetColumns(change)
+			changes = append(changes, change)
+		}
+		return changes
+	}
+
+	statement, err := grammar.ParseStatement(query)
+	if err != nil {
+		change := newChange(tableNameRegexp.FindString(matches[6]))
+		change.Unsupported = fmt.Sprintf("Could not parse statement: %s", err)
+		d.forgetColumns(change)
+		return []*SchemaChange{change}
+	}
+
+	var change *SchemaChange
+	if statement.CreateTable != nil {
+		change = newChange(statement.CreateTable.Name)
+		err = d.createTable(change, statement.CreateTable)
+	} else {
+		change = newChange(statement.AlterTable.Name)
+		err = d.alterTable(change, statement.AlterTable)
+	}
+	if err != nil {
+		change.Unsupported = err.Error()
+		d.forgetColumns(change)
+	}
+	return []*SchemaChange{change}
+}
+
+func (d *Decoder) forgetColumns(change *SchemaChange) {
+	delete(d.columns, change.Database+"."+change.Table)
+}
+
+func (d *Decoder) setColumns(change *SchemaChange, columns []*mysql.ColumnMetadata) {
+	for i, c := range columns {
+		c.OrdinalPosition = i + 1
+	}
+	change.Columns = columns
+	d.columns[change.Database+"."+change.Table] = columns
+}
+
+func (d *Decoder) createTable(change *SchemaChange, ct *grammar.CreateTable) error {
+	if _, ok := d.columns[change.Database+"."+change.Table]; ok && ct.IfNotExists {
+		return nil
+	}
+
+	var columns []*mysql.ColumnMetadata
+	var primaryKey []string
+	for _, def := range ct.CreateDefinition {
+		switch {
+		case def.ColumnDefinition != nil:
+			c, err := mysql.ColumnMetadataFromDefinition(def.ColumnDefinition)
+			if err != nil {
+				return err
+			}
+			columns = append(columns, c)
+		case def.PrimaryKeyDefinition != nil:
+			for _, key := range def.PrimaryKeyDefinition.Keys {
+				if key.KeyPartColumn == nil {
+					return errors.New("Primary key on an expression")
+				}
+				primaryKey = append(primaryKey, key.KeyPartColumn.Name)
+			}
+		}
+	}
+	for _, name := range primaryKey {
+		c := findColumn(columns, name)
+		if c == nil {
+			return errors.Errorf("Primary key column %s not found", name)
+		}
+		c.ColumnKey = "PRI"
+		c.IsNullable = "NO"
+	}
+
+	change.Created = true
+	d.setColumns(change, columns)
+	if ct.IfNotExists {
+		// the table might already exist with other columns, let the source tell
+		d.forgetColumns(change)
+	}
+	return nil
+}
+
+func findColumn(columns []*mysql.ColumnMetadata, name string) *mysql.ColumnMetadata {
+	for _, c := range columns {
+		if strings.EqualFold(c.ColumnName, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) alterTable(change *SchemaChange, at *grammar.AlterTable) error {
+	var added []*grammar.AddSingleColumn
+	for _, o := range at.AlterOptions {
+		switch {
+		case o.AddSingleColumn != nil:
+			added = append(added, o.AddSingleColumn)
+		case o.AddMultipleColumns != nil:
+			for _, def := range o.AddMultipleColumns {
+				added = append(added, &grammar.AddSingleColumn{ColumnDefinition: def})
+			}
+		case o.AddPrimaryKeyDefinition != nil, o.DropPrimaryKey:
+			return errors.New("Changing the primary key can't be applied to postgresql")
+		case o.DropColumn != nil:
+			return errors.New("DROP COLUMN can't be applied to postgresql")
+		case o.ModifyColumn != nil, o.ChangeColumn != nil:
+			return errors.New("Changing a column definition can't be applied to postgresql")
+		case o.RenameColumn != nil, o.RenameTable != nil:
+			return errors.New("Renames can't be applied to postgresql")
+		}
+		// everything else changes indexes, constraints, defaults or table options, which
+		// don't affect the replicated data
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	// the binlog is behind the schema source, which might already have the new columns
+	previous, err := d.getColumns(change.Database, change.Table)
+	if err != nil {
+		return err
+	}
+	columns := append([]*mysql.ColumnMetadata{}, previous...)
+
+	for _, a := range added {
+		c, err := mysql.ColumnMetadataFromDefinition(a.ColumnDefinition)
+		if err != nil {
+			return err
+		}
+		if c.ColumnKey == "PRI" {
+			return errors.New("Changing the primary key can't be applied to postgresql")
+		}
+		value, err := getExistingRowsValue(c, change.Timestamp)
+		if err != nil {
+			return errors.Wrapf(err, "Could not add column %s", c.ColumnName)
+		}
+		change.AddedColumns = append(change.AddedColumns, &AddedColumn{Column: c, Value: value})
+
+		if findColumn(columns, c.ColumnName) != nil {
+			continue
+		}
+		switch {
+		case a.IsFirst:
+			columns = append([]*mysql.ColumnMetadata{c}, columns...)
+		case a.After != nil:
+			after := findColumn(columns, *a.After)
+			if after == nil {
+				return errors.Errorf("Column %s not found", *a.After)
+			}
+			for i := range columns {
+				if columns[i] == after {
+					columns = append(columns[:i+1], append([]*mysql.ColumnMetadata{c}, columns[i+1:]...)...)
+					break
+				}
+			}
+		default:
+			columns = append(columns, c)
+		}
+	}
+
+	d.setColumns(change, columns)
+	return nil
+}
+
+// getExistingRowsValue returns the value mysql gives the existing rows when adding c.
+func getExistingRowsValue(c *mysql.ColumnMetadata, timestamp time.Time) (*string, error) {
+	if c.IsGenerated() {
+		return nil, errors.New("Generated columns can't be applied to postgresql")
+	}
+	if c.IsAutoIncrement() {
+		return nil, errors.New("Auto increment columns can't be applied to postgresql")
+	}
+	if c.IsDefaultGenerated() {
+		// the rows get the time of the statement
+		s := timestamp.Format("2006-01-02 15:04:05")
+		return &s, nil
+	}
+	if c.ColumnDefault != nil || c.IsNullable == "YES" {
+		return c.ColumnDefault, nil
+	}
+
+	// NOT NULL without default gets the implicit default of the type
+	var s string
+	switch c.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "bigint", "decimal", "float", "double", "bit", "year":
+		s = "0"
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext",
+		"binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "set":
+		s = ""
+	case "enum":
+		values := c.GetEnumValues()
+		if len(values) == 0 {
+			return nil, errors.New("Enum without values")
+		}
+		s = values[0]
+	case "date":
+		s = "0000-00-00"
+	case "datetime", "timestamp":
+		s = "0000-00-00 00:00:00"
+	case "time":
+		s = "00:00:00"
+	case "json":
+		s = "null"
+	default:
+		return nil, errors.Errorf("No implicit default for NOT NULL %s column", c.DataType)
+	}
+	return &s, nil
+}
@@ -0,0 +1,126 @@
+package binlog
+
+import (
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+type testSource map[string][]*mysql.ColumnMetadata
+
+func (s testSource) GetColumnMetadata(schema string, table string) ([]*mysql.ColumnMetadata, error) {
+	return s[schema+"."+table], nil
+}
+
+func queryEvent(schema string, query string) *replication.BinlogEvent {
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{Timestamp: 1700000000, LogPos: 1234},
+		Event:  &replication.QueryEvent{Schema: []byte(schema), Query: []byte(query)},
+	}
+}
+
+func decodeQuery(t *testing.T, d *Decoder, query string) []*SchemaChange {
+	changes, err := d.Decode(queryEvent("wordpress", query))
+	require.Nil(t, err)
+	assert.Empty(t, changes)
+	assert.True(t, d.IsCommit())
+	return d.SchemaChanges()
+}
+
+func columnNames(columns []*mysql.ColumnMetadata) []string {
+	var names []string
+	for _, c := range columns {
+		names = append(names, c.ColumnName)
+	}
+	return names
+}
+
+func TestDecodeAddColumn(t *testing.T) {
+	d, err := NewDecoder(testSource{
+		"wordpress.wp_wc_orders": {
+			{ColumnName: "id", DataType: "bigint", ColumnType: "bigint unsigned", ColumnKey: "PRI"},
+			{ColumnName: "status", DataType: "varchar", ColumnType: "varchar(20)"},
+		},
+	}, mysql.MySQLFlavor, Position{})
+	require.Nil(t, err)
+
+	changes := decodeQuery(t, d, "/* ApplicationName=DBeaver */ ALTER TABLE `wp_wc_orders` "+
+		"ADD COLUMN `note` text AFTER `id`, ADD `total` decimal(26,8) NOT NULL, "+
+		"ADD COLUMN `paid_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, ADD INDEX `status` (`status`)")
+	require.Len(t, changes, 1)
+	change := changes[0]
+	assert.Equal(t, "wordpress", change.Database)
+	assert.Equal(t, "wp_wc_orders", change.Table)
+	assert.Empty(t, change.Unsupported)
+	assert.Equal(t, []string{"id", "note", "status", "total", "paid_at"}, columnNames(change.Columns))
+	assert.Equal(t, 2, change.Columns[1].OrdinalPosition)
+
+	require.Len(t, change.AddedColumns, 3)
+	assert.Nil(t, change.AddedColumns[0].Value)
+	assert.Equal(t, "decimal(26,8)", change.AddedColumns[1].Column.ColumnType)
+	assert.Equal(t, "0", *change.AddedColumns[1].Value)
+	assert.Equal(t, "2023-11-14 22:13:20", *change.AddedColumns[2].Value)
+
+	// the next row events are decoded with the new columns
+	columns, err := d.getColumns("wordpress", "wp_wc_orders")
+	require.Nil(t, err)
+	assert.Equal(t, change.Columns, columns)
+
+	changes = decodeQuery(t, d, "ALTER TABLE wp_wc_orders ADD INDEX total (total), ALGORITHM=INPLACE")
+	require.Len(t, changes, 1)
+	assert.True(t, changes[0].IsEmpty())
+}
+
+func TestDecodeUnsupportedSchemaChanges(t *testing.T) {
+	d, err := NewDecoder(testSource{}, mysql.MySQLFlavor, Position{})
+	require.Nil(t, err)
+
+	for _, tc := range []struct {
+		query  string
+		tables []string
+	}{
+		{"ALTER TABLE wp_posts DROP COLUMN post_excerpt", []string{"wp_posts"}},
+		{"ALTER TABLE wp_posts MODIFY post_title varchar(255) NOT NULL", []string{"wp_posts"}},
+		{"ALTER TABLE `wp_posts` ADD COLUMN id2 int AUTO_INCREMENT", []string{"wp_posts"}},
+		{"ALTER TABLE wp_posts PARTITION BY HASH(id)", []string{"wp_posts"}},
+		{"DROP TABLE IF EXISTS `wp_a`,`wp_b` /* generated by server */", []string{"wp_a", "wp_b"}},
+		{"RENAME TABLE wp_a TO wp_old_a, wp_b TO wp_old_b", []string{"wp_a", "wp_b"}},
+		{"TRUNCATE wp_options", []string{"wp_options"}},
+		{"CREATE TABLE wp_copy LIKE wp_posts", []string{"wp_copy"}},
+	} {
+		changes := decodeQuery(t, d, tc.query)
+		var tables []string
+		for _, change := range changes {
+			assert.NotEmpty(t, change.Unsupported, tc.query)
+			tables = append(tables, change.Table)
+		}
+		assert.Equal(t, tc.tables, tables, tc.query)
+	}
+
+	for _, query := range []string{"COMMIT", "CREATE USER majipoor", "DROP TEMPORARY TABLE IF EXISTS tmp", "GRANT ALL ON *.* TO x"} {
+		assert.Empty(t, decodeQuery(t, d, query), query)
+	}
+}
+
+func TestDecodeCreateTable(t *testing.T) {
+	d, err := NewDecoder(testSource{}, mysql.MySQLFlavor, Position{})
+	require.Nil(t, err)
+
+	changes := decodeQuery(t, d, "CREATE TABLE shop.`orders` (\n"+
+		"  `id` int unsigned NOT NULL AUTO_INCREMENT,\n"+
+		"  `state` enum('new','paid') NOT NULL DEFAULT 'new',\n"+
+		"  PRIMARY KEY (`id`)\n"+
+		") ENGINE=InnoDB")
+	require.Len(t, changes, 1)
+	change := changes[0]
+	assert.Equal(t, "shop", change.Database)
+	assert.Equal(t, "orders", change.Table)
+	assert.True(t, change.Created)
+	require.Len(t, change.Columns, 2)
+	assert.Equal(t, "PRI", change.Columns[0].ColumnKey)
+	assert.Equal(t, "int unsigned", change.Columns[0].ColumnType)
+	assert.Equal(t, []string{"new", "paid"}, change.Columns[1].GetEnumValues())
+	assert.Equal(t, "new", *change.Columns[1].ColumnDefault)
+}
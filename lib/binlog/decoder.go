@@ -0,0 +1,341 @@
+package binlog
+
+import (
+	"fmt"
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"majipoor/lib/mysql"
+	"strings"
+	"time"
+)
+
+// SchemaSource returns the columns of a mysql table, in ordinal order.
+type SchemaSource interface {
+	GetColumnMetadata(schema string, table string) ([]*mysql.ColumnMetadata, error)
+}
+
+// Position is a point in the binlog stream, right after a committed transaction.
+type Position struct {
+	GTIDSet string
+	File    string
+	Pos     uint32
+}
+
+// Decoder turns binlog events into RowChanges.
+//
+// Row events only carry column positions, so the column names and types are looked up
+// in the SchemaHistory, or through the SchemaSource, and cached.
+//
+// DDL statements update the cached columns, and are returned by SchemaChanges.
+//
+// The decoder also keeps track of the position of the last committed transaction,
+// to be checkpointed along with the applied changes.
+type Decoder struct {
+	Source SchemaSource
+	// History is optional, it takes precedence over Source
+	History SchemaHistory
+	// SkipRows only tracks the position, without decoding row events nor DDL statements
+	SkipRows bool
+
+	currentGTID string
+	currentFile string
+	executed    gomysql.GTIDSet
+	position    Position
+	commitTime  time.Time
+	committed   bool
+	trackGTIDs  bool
+	changes     []*SchemaChange
+	columns     map[string][]*mysql.ColumnMetadata
+}
+
+// NewDecoder returns a decoder for a stream of a server of flavor (mysql or mariadb) starting at start.
+//
+// A stream started from a binlog file and position, without GTID set, is tracked by file
+// and position only: the GTIDs it sees would not make a complete executed set.
+func NewDecoder(source SchemaSource, flavor string, start Position) (*Decoder, error) {
+	executed, err := gomysql.ParseGTIDSet(flavor, start.GTIDSet)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not parse GTID set %s", start.GTIDSet)
+	}
+	return &Decoder{
+		Source:      source,
+		currentFile: start.File,
+		executed:    executed,
+		position:    start,
+		trackGTIDs:  start.GTIDSet != "" || start.File == "",
+		columns:     map[string][]*mysql.ColumnMetadata{},
+	}, nil
+}
+
+// SchemaChanges returns the changes of the DDL statement decoded last, if it was one.
+func (d *Decoder) SchemaChanges() []*SchemaChange {
+	return d.changes
+}
+
+// Position returns the position right after the last committed transaction.
+func (d *Decoder) Position() Position {
+	return d.position
+}
+
+// CommitTime returns the time the last committed transaction was written to the binlog.
+func (d *Decoder) CommitTime() time.Time {
+	return d.commitTime
+}
+
+// IsCommit returns true if the last decoded event committed a transaction.
+func (d *Decoder) IsCommit() bool {
+	return d.committed
+}
+
+func (d *Decoder) commit(header *replication.EventHeader) error {
+	if d.currentGTID != "" && d.trackGTIDs {
+		if err := d.executed.Update(d.currentGTID); err != nil {
+			return errors.Wrapf(err, "Could not add GTID %s to executed set", d.currentGTID)
+		}
+	}
+	d.position = Position{
+		GTIDSet: d.executed.String(),
+		File:    d.currentFile,
+		Pos:     header.LogPos,
+	}
+	d.commitTime = time.Unix(int64(header.Timestamp), 0).UTC()
+	d.committed = true
+	return nil
+}
+
+// CurrentGTID is the GTID of the transaction the last decoded event belongs to.
+func (d *Decoder) CurrentGTID() string {
+	return d.currentGTID
+}
+
+func (d *Decoder) getColumns(database string, table string) ([]*mysql.ColumnMetadata, error) {
+	key := database + "." + table
+	if columns, ok := d.columns[key]; ok {
+		return columns, nil
+	}
+	if d.History != nil {
+		versions, err := d.History.GetSchemaVersions(database, table)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not get schema history of %s", key)
+		}
+		version, err := versionAt(versions, d.position)
+		if err != nil {
+			return nil, err
+		}
+		if version != nil {
+			d.columns[key] = version.Columns
+			return version.Columns, nil
+		}
+		log.Warn().Str("table", key).Str("gtid-set", d.position.GTIDSet).
+			Msg("No schema version recorded at this position, using the current columns")
+	}
+	columns, err := d.Source.GetColumnMetadata(database, table)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get metadata for %s", key)
+	}
+	d.columns[key] = columns
+	return columns, nil
+}
+
+func formatGTID(e *replication.GTIDEvent) (string, error) {
+	sid, err := uuidFromBytes(e.SID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", sid, e.GNO), nil
+}
+
+func uuidFromBytes(b []byte) (string, error) {
+	if len(b) != 16 {
+		return "", errors.Errorf("Invalid server uuid length %d", len(b))
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Decode returns the row changes contained in ev. Events that are not row events
+// return no changes, but GTID, rotate and commit events are tracked to tag the following
+// changes and to keep the position up to date.
+func (d *Decoder) Decode(ev *replication.BinlogEvent) ([]*RowChange, error) {
+	d.committed = false
+	d.changes = nil
+
+	switch e := ev.Event.(type) {
+	case *replication.RotateEvent:
+		d.currentFile = string(e.NextLogName)
+		return nil, nil
+
+	case *replication.XIDEvent:
+		return nil, d.commit(ev.Header)
+
+	case *replication.QueryEvent:
+		// BEGIN starts a transaction, anything else (COMMIT for non transactional engines, DDL)
+		// ends one
+		if string(e.Query) != "BEGIN" {
+			if !d.SkipRows {
+				d.changes = d.decodeSchemaChanges(ev.Header, e)
+			}
+			return nil, d.commit(ev.Header)
+		}
+		return nil, nil
+
+	case *replication.MariadbGTIDEvent:
+		d.currentGTID = e.GTID.String()
+		return nil, nil
+
+	case *replication.GTIDEvent:
+		if ev.Header.EventType == replication.ANONYMOUS_GTID_EVENT {
+			// servers without GTIDs still log a GTID event in front of every transaction
+			d.currentGTID = ""
+			return nil, nil
+		}
+		gtid, err := formatGTID(e)
+		if err != nil {
+			return nil, err
+		}
+		d.currentGTID = gtid
+		return nil, nil
+
+	case *replication.RowsEvent:
+		if d.SkipRows {
+			return nil, nil
+		}
+		var operation Operation
+		switch ev.Header.EventType {
+		case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+			operation = OperationInsert
+		case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+			operation = OperationUpdate
+		case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+			operation = OperationDelete
+		default:
+			return nil, nil
+		}
+		return d.decodeRows(ev.Header, e, operation)
+	}
+
+	return nil, nil
+}
+
+func (d *Decoder) decodeRows(header *replication.EventHeader, e *replication.RowsEvent, operation Operation) ([]*RowChange, error) {
+	database := string(e.Table.Schema)
+	table := string(e.Table.Table)
+	columns, err := d.getColumns(database, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) != int(e.ColumnCount) {
+		return nil, errors.Errorf("Table %s.%s has %d columns, but the binlog event has %d",
+			database, table, len(columns), e.ColumnCount)
+	}
+
+	timestamp := time.Unix(int64(header.Timestamp), 0).UTC()
+	newChange := func() *RowChange {
+		return &RowChange{
+			Database:  database,
+			Table:     table,
+			Operation: operation,
+			Columns:   columns,
+			GTID:      d.currentGTID,
+			Timestamp: timestamp,
+		}
+	}
+
+	var changes []*RowChange
+	switch operation {
+	case OperationUpdate:
+		// update events contain pairs of before and after images
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			change := newChange()
+			change.Before = normalizeRow(columns, e.Rows[i])
+			change.After = normalizeRow(columns, e.Rows[i+1])
+			changes = append(changes, change)
+		}
+	case OperationInsert:
+		for _, row := range e.Rows {
+			change := newChange()
+			change.After = normalizeRow(columns, row)
+			changes = append(changes, change)
+		}
+	case OperationDelete:
+		for _, row := range e.Rows {
+			change := newChange()
+			change.Before = normalizeRow(columns, row)
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+func normalizeRow(columns []*mysql.ColumnMetadata, row []interface{}) []interface{} {
+	res := make([]interface{}, len(row))
+	for i, v := range row {
+		res[i] = normalizeValue(columns[i], v)
+	}
+	return res
+}
+
+// normalizeValue fixes up the raw values decoded by go-mysql, which don't know about
+// signedness (before mysql 8.0.1) or enum and set values.
+func normalizeValue(c *mysql.ColumnMetadata, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch c.DataType {
+	case "enum":
+		if idx, ok := v.(int64); ok {
+			values := c.GetEnumValues()
+			// index 0 is the empty string mysql stores for invalid values
+			if idx < 1 || int(idx) > len(values) {
+				return ""
+			}
+			return values[idx-1]
+		}
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	case "set":
+		if bits, ok := v.(int64); ok {
+			var res []string
+			for i, value := range c.GetEnumValues() {
+				if bits&(1<<uint(i)) != 0 {
+					res = append(res, value)
+				}
+			}
+			return strings.Join(res, ",")
+		}
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	case "json", "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		// go-mysql decodes the TEXT types as blobs
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	case "binary", "varbinary":
+		if s, ok := v.(string); ok {
+			return []byte(s)
+		}
+	}
+
+	if c.IsUnsigned() {
+		switch x := v.(type) {
+		case int8:
+			return uint8(x)
+		case int16:
+			return uint16(x)
+		case int32:
+			if c.DataType == "mediumint" {
+				return uint32(x) & 0xFFFFFF
+			}
+			return uint32(x)
+		case int64:
+			return uint64(x)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,58 @@
+package binlog
+
+import (
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func TestDecodeFilePosition(t *testing.T) {
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	events := []*replication.BinlogEvent{
+		{Header: &replication.EventHeader{}, Event: &replication.RotateEvent{NextLogName: []byte("mysql-bin.000007")}},
+		{Header: &replication.EventHeader{EventType: replication.ANONYMOUS_GTID_EVENT}, Event: &replication.GTIDEvent{}},
+		{Header: &replication.EventHeader{EventType: replication.XID_EVENT, LogPos: 500}, Event: &replication.XIDEvent{}},
+		// a stream started from a file position doesn't know the executed GTID set
+		{Header: &replication.EventHeader{EventType: replication.GTID_EVENT}, Event: &replication.GTIDEvent{SID: sid, GNO: 42}},
+		{Header: &replication.EventHeader{EventType: replication.XID_EVENT, LogPos: 900}, Event: &replication.XIDEvent{}},
+	}
+
+	d, err := NewDecoder(testSource{}, mysql.MySQLFlavor, Position{File: "mysql-bin.000006", Pos: 4})
+	require.Nil(t, err)
+	for _, ev := range events {
+		_, err = d.Decode(ev)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, Position{File: "mysql-bin.000007", Pos: 900}, d.Position())
+	assert.Equal(t, testUUID+":42", d.CurrentGTID())
+
+	d, err = NewDecoder(testSource{}, mysql.MySQLFlavor, Position{GTIDSet: testUUID + ":1-41"})
+	require.Nil(t, err)
+	for _, ev := range events {
+		_, err = d.Decode(ev)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, Position{GTIDSet: testUUID + ":1-42", File: "mysql-bin.000007", Pos: 900}, d.Position())
+}
+
+func TestDecodeMariaDBGTID(t *testing.T) {
+	d, err := NewDecoder(testSource{}, mysql.MariaDBFlavor, Position{GTIDSet: "0-1-100"})
+	require.Nil(t, err)
+	for _, ev := range []*replication.BinlogEvent{
+		{Header: &replication.EventHeader{EventType: replication.MARIADB_GTID_EVENT},
+			Event: &replication.MariadbGTIDEvent{GTID: gomysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: 101}}},
+		{Header: &replication.EventHeader{EventType: replication.XID_EVENT, LogPos: 300}, Event: &replication.XIDEvent{}},
+	} {
+		_, err = d.Decode(ev)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, "0-1-101", d.CurrentGTID())
+	assert.Equal(t, "0-1-101", d.Position().GTIDSet)
+
+	ok, err := d.Position().Includes(Position{GTIDSet: "0-1-100"})
+	require.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,105 @@
+package binlog
+
+import (
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Filter drops the row events of other databases and of filtered tables, before they are decoded.
+//
+// Tables are filtered on their TableMapEvent, which precedes the row events of each table in
+// every transaction, and the decision is remembered by table id for the row events.
+// Other events are never filtered, as they are needed to keep track of the position.
+type Filter struct {
+	Database string
+	// LimitTables restricts the stream to these tables, if not empty
+	LimitTables []string
+	SkipTables  []string
+
+	limitTables map[string]bool
+	skipTables  map[string]bool
+	included    map[string]bool
+	allowed     map[uint64]bool
+	skipped     SkipCounts
+}
+
+// SkipCounts counts the row events, and the rows they contain, dropped by a Filter.
+type SkipCounts struct {
+	OtherDatabaseEvents int64
+	OtherDatabaseRows   int64
+	FilteredTableEvents int64
+	FilteredTableRows   int64
+}
+
+func NewFilter(database string, limitTables []string, skipTables []string) *Filter {
+	f := &Filter{
+		Database:    database,
+		LimitTables: limitTables,
+		SkipTables:  skipTables,
+		limitTables: map[string]bool{},
+		skipTables:  map[string]bool{},
+		included:    map[string]bool{},
+		allowed:     map[uint64]bool{},
+	}
+	for _, t := range limitTables {
+		f.limitTables[t] = true
+	}
+	for _, t := range skipTables {
+		f.skipTables[t] = true
+	}
+	return f
+}
+
+// Include lets the events of a table through, whatever its database and the table filters.
+func (f *Filter) Include(database string, table string) {
+	f.included[database+"."+table] = true
+}
+
+// AllowsTable mirrors the filtering of mysql.GetTables.
+func (f *Filter) AllowsTable(database string, table string) bool {
+	if f.included[database+"."+table] {
+		return true
+	}
+	if database != f.Database {
+		return false
+	}
+	if f.skipTables[table] {
+		return false
+	}
+	if len(f.limitTables) > 0 && !f.limitTables[table] {
+		return false
+	}
+	return true
+}
+
+// Allows returns false if ev is a TableMapEvent or a row event of a filtered table.
+func (f *Filter) Allows(ev *replication.BinlogEvent) bool {
+	switch e := ev.Event.(type) {
+	case *replication.TableMapEvent:
+		allowed := f.AllowsTable(string(e.Schema), string(e.Table))
+		f.allowed[e.TableID] = allowed
+		return allowed
+
+	case *replication.RowsEvent:
+		allowed, ok := f.allowed[e.TableID]
+		if !ok {
+			// the parser always sees the TableMapEvent first, but be safe
+			allowed = f.AllowsTable(string(e.Table.Schema), string(e.Table.Table))
+		}
+		if !allowed {
+			if string(e.Table.Schema) != f.Database {
+				f.skipped.OtherDatabaseEvents++
+				f.skipped.OtherDatabaseRows += int64(len(e.Rows))
+			} else {
+				f.skipped.FilteredTableEvents++
+				f.skipped.FilteredTableRows += int64(len(e.Rows))
+			}
+		}
+		return allowed
+	}
+
+	return true
+}
+
+func (f *Filter) Skipped() SkipCounts {
+	return f.skipped
+}
@@ -0,0 +1,49 @@
+package binlog
+
+import (
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func tableMapEvent(id uint64, schema string, table string) *replication.BinlogEvent {
+	return &replication.BinlogEvent{
+		Event: &replication.TableMapEvent{TableID: id, Schema: []byte(schema), Table: []byte(table)},
+	}
+}
+
+func rowsEvent(tableMap *replication.BinlogEvent, rows int) *replication.BinlogEvent {
+	e := tableMap.Event.(*replication.TableMapEvent)
+	return &replication.BinlogEvent{
+		Event: &replication.RowsEvent{TableID: e.TableID, Table: e, Rows: make([][]interface{}, rows)},
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := NewFilter("wordpress", nil, []string{"wp_sessions"})
+
+	posts := tableMapEvent(1, "wordpress", "wp_posts")
+	sessions := tableMapEvent(2, "wordpress", "wp_sessions")
+	other := tableMapEvent(3, "shop", "orders")
+
+	assert.True(t, f.Allows(posts))
+	assert.True(t, f.Allows(rowsEvent(posts, 2)))
+	assert.False(t, f.Allows(sessions))
+	assert.False(t, f.Allows(rowsEvent(sessions, 3)))
+	assert.False(t, f.Allows(other))
+	assert.False(t, f.Allows(rowsEvent(other, 1)))
+	assert.True(t, f.Allows(&replication.BinlogEvent{Event: &replication.XIDEvent{}}))
+
+	assert.Equal(t, SkipCounts{
+		OtherDatabaseEvents: 1,
+		OtherDatabaseRows:   1,
+		FilteredTableEvents: 1,
+		FilteredTableRows:   3,
+	}, f.Skipped())
+
+	f = NewFilter("wordpress", []string{"wp_posts"}, nil)
+	assert.True(t, f.AllowsTable("wordpress", "wp_posts"))
+	assert.False(t, f.AllowsTable("wordpress", "wp_options"))
+	f.Include("wordpress", "majipoor_heartbeat")
+	assert.True(t, f.AllowsTable("wordpress", "majipoor_heartbeat"))
+}
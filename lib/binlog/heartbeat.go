@@ -0,0 +1,33 @@
+package binlog
+
+import (
+	"majipoor/lib/mysql"
+	"time"
+)
+
+// Heartbeat recognizes the row changes of the heartbeat table (see mysql.WriteHeartbeat).
+// Heartbeats keep the lag measurable when the replicated tables are idle, they are not applied.
+type Heartbeat struct {
+	Database string
+	Table    string
+}
+
+func (h *Heartbeat) Matches(database string, table string) bool {
+	return database == h.Database && table == h.Table
+}
+
+// Time returns the time of a heartbeat row change, or false if change isn't one.
+func (h *Heartbeat) Time(change *RowChange) (time.Time, bool) {
+	if !h.Matches(change.Database, change.Table) || change.Operation == OperationDelete {
+		return time.Time{}, false
+	}
+	for i, c := range change.Columns {
+		if c.ColumnName != mysql.HeartbeatTimeColumn || i >= len(change.After) {
+			continue
+		}
+		if micros, ok := change.After[i].(int64); ok {
+			return time.UnixMicro(micros).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,24 @@
+package binlog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"majipoor/lib/mysql"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatTime(t *testing.T) {
+	h := &Heartbeat{Database: "wordpress", Table: "majipoor_heartbeat"}
+	columns := []*mysql.ColumnMetadata{{ColumnName: "server_id"}, {ColumnName: "written_at"}}
+	written := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+
+	change := &RowChange{Database: "wordpress", Table: "majipoor_heartbeat", Operation: OperationUpdate,
+		Columns: columns, After: []interface{}{uint32(100), written.UnixMicro()}}
+	ts, ok := h.Time(change)
+	assert.True(t, ok)
+	assert.Equal(t, written, ts)
+
+	change.Table = "wp_posts"
+	_, ok = h.Time(change)
+	assert.False(t, ok)
+}
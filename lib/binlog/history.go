@@ -0,0 +1,96 @@
+package binlog
+
+import (
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pkg/errors"
+	"majipoor/lib/mysql"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the columns of a table from a position of the binlog on.
+type SchemaVersion struct {
+	Position Position
+	Columns  []*mysql.ColumnMetadata
+}
+
+// SchemaHistory returns the recorded versions of a table, oldest first.
+//
+// Versions are recorded when a table is snapshotted and when a schema change is applied,
+// so that events can be decoded with the columns the table had when they were written,
+// rather than with the current columns of information_schema.
+type SchemaHistory interface {
+	GetSchemaVersions(database string, table string) ([]*SchemaVersion, error)
+}
+
+// gtidFlavor guesses the flavor of a GTID set: mysql GTIDs are uuid:interval,
+// mariadb GTIDs are domain-server-sequence.
+func gtidFlavor(set string) string {
+	if set != "" && !strings.Contains(set, ":") {
+		return gomysql.MariaDBFlavor
+	}
+	return gomysql.MySQLFlavor
+}
+
+// Includes returns true if other is at or before p. GTID sets are compared if both positions
+// have one, binlog files and positions otherwise.
+func (p Position) Includes(other Position) (bool, error) {
+	if p.GTIDSet != "" && other.GTIDSet != "" {
+		set, err := gomysql.ParseGTIDSet(gtidFlavor(p.GTIDSet), p.GTIDSet)
+		if err != nil {
+			return false, errors.Wrapf(err, "Could not parse GTID set %s", p.GTIDSet)
+		}
+		otherSet, err := gomysql.ParseGTIDSet(gtidFlavor(other.GTIDSet), other.GTIDSet)
+		if err != nil {
+			return false, errors.Wrapf(err, "Could not parse GTID set %s", other.GTIDSet)
+		}
+		return set.Contain(otherSet), nil
+	}
+	if p.File == "" || other.File == "" {
+		return false, errors.New("Positions without GTID set nor binlog file can't be compared")
+	}
+	base, n, err := parseBinlogFile(p.File)
+	if err != nil {
+		return false, err
+	}
+	otherBase, otherN, err := parseBinlogFile(other.File)
+	if err != nil {
+		return false, err
+	}
+	if base != otherBase {
+		return false, errors.Errorf("Binlog files %s and %s of different servers can't be compared", p.File, other.File)
+	}
+	if n != otherN {
+		return n > otherN, nil
+	}
+	return p.Pos >= other.Pos, nil
+}
+
+// parseBinlogFile returns the base name and the number of a binlog file (mysql-bin.000042).
+// The number is zero padded to 6 digits, and gets wider past 999999.
+func parseBinlogFile(file string) (string, uint64, error) {
+	i := strings.LastIndex(file, ".")
+	if i < 0 {
+		return "", 0, errors.Errorf("Binlog file %s has no number", file)
+	}
+	n, err := strconv.ParseUint(file[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, errors.Errorf("Binlog file %s has no number", file)
+	}
+	return file[:i], n, nil
+}
+
+// versionAt returns the last of versions that is valid at position, or nil if there is none.
+func versionAt(versions []*SchemaVersion, position Position) (*SchemaVersion, error) {
+	var res *SchemaVersion
+	for _, v := range versions {
+		ok, err := position.Includes(v.Position)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = v
+		}
+	}
+	return res, nil
+}
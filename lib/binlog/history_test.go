@@ -0,0 +1,72 @@
+package binlog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+const testUUID = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+type testHistory map[string][]*SchemaVersion
+
+func (h testHistory) GetSchemaVersions(database string, table string) ([]*SchemaVersion, error) {
+	return h[database+"."+table], nil
+}
+
+func TestPositionIncludes(t *testing.T) {
+	for _, tc := range []struct {
+		p, other Position
+		expected bool
+	}{
+		{Position{GTIDSet: testUUID + ":1-10"}, Position{GTIDSet: testUUID + ":1-5"}, true},
+		{Position{GTIDSet: testUUID + ":1-10"}, Position{GTIDSet: testUUID + ":1-10"}, true},
+		{Position{GTIDSet: testUUID + ":1-5"}, Position{GTIDSet: testUUID + ":1-10"}, false},
+		{Position{File: "mysql-bin.000002", Pos: 4}, Position{File: "mysql-bin.000001", Pos: 1000}, true},
+		{Position{File: "mysql-bin.000002", Pos: 4}, Position{File: "mysql-bin.000002", Pos: 1000}, false},
+		// the numbers get wider past 999999
+		{Position{File: "mysql-bin.1000000", Pos: 4}, Position{File: "mysql-bin.999999", Pos: 1000}, true},
+		{Position{File: "mysql-bin.999999", Pos: 1000}, Position{File: "mysql-bin.1000000", Pos: 4}, false},
+	} {
+		ok, err := tc.p.Includes(tc.other)
+		require.Nil(t, err)
+		assert.Equal(t, tc.expected, ok, "%v includes %v", tc.p, tc.other)
+	}
+
+	_, err := Position{}.Includes(Position{File: "mysql-bin.000001"})
+	assert.NotNil(t, err)
+	_, err = Position{File: "mysql-bin.000002"}.Includes(Position{File: "other-bin.000001"})
+	assert.NotNil(t, err)
+	_, err = Position{File: "mysql-bin.000002"}.Includes(Position{File: "mysql-bin"})
+	assert.NotNil(t, err)
+}
+
+func TestDecodeWithHistory(t *testing.T) {
+	v1 := []*mysql.ColumnMetadata{{ColumnName: "id"}}
+	v2 := []*mysql.ColumnMetadata{{ColumnName: "id"}, {ColumnName: "note"}}
+	history := testHistory{
+		"wordpress.wp_posts": {
+			{Position: Position{GTIDSet: testUUID + ":1-5"}, Columns: v1},
+			{Position: Position{GTIDSet: testUUID + ":1-8"}, Columns: v2},
+		},
+	}
+	current := []*mysql.ColumnMetadata{{ColumnName: "id"}, {ColumnName: "note"}, {ColumnName: "author"}}
+
+	for _, tc := range []struct {
+		start    string
+		expected []*mysql.ColumnMetadata
+	}{
+		{testUUID + ":1-3", current},
+		{testUUID + ":1-6", v1},
+		{testUUID + ":1-8", v2},
+		{testUUID + ":1-20", v2},
+	} {
+		d, err := NewDecoder(testSource{"wordpress.wp_posts": current}, mysql.MySQLFlavor, Position{GTIDSet: tc.start})
+		require.Nil(t, err)
+		d.History = history
+		columns, err := d.getColumns("wordpress", "wp_posts")
+		require.Nil(t, err)
+		assert.Equal(t, tc.expected, columns, tc.start)
+	}
+}
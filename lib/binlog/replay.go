@@ -0,0 +1,141 @@
+package binlog
+
+import (
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"time"
+)
+
+// errStopReplay stops the parsing of binlog files once the stop position or time is reached.
+var errStopReplay = errors.New("stop replay")
+
+// ReplayRange selects the events of binlog files to replay, like the options of mysqlbinlog.
+// Zero values don't restrict anything.
+//
+// Transactions before StartTime or excluded by their GTID keep their commit, with no row
+// change nor DDL statement, so that the position moves past them.
+type ReplayRange struct {
+	// StartPosition is the position of the first event in the first file
+	StartPosition uint32
+	// StopPosition stops before the first event at or after this position in the last file
+	StopPosition uint32
+	StartTime    time.Time
+	// StopTime stops before the first event at or after this time
+	StopTime time.Time
+	// IncludeGTIDs keeps only the transactions of this set, ExcludeGTIDs drops those of this set
+	IncludeGTIDs gomysql.GTIDSet
+	ExcludeGTIDs gomysql.GTIDSet
+
+	skipping bool
+}
+
+func (r *ReplayRange) stops(ev *replication.BinlogEvent, last bool) bool {
+	// artificial events have no position, the subtraction would wrap around
+	if last && r.StopPosition > 0 && ev.Header.LogPos >= ev.Header.EventSize &&
+		ev.Header.LogPos-ev.Header.EventSize >= r.StopPosition {
+		return true
+	}
+	// artificial events have no timestamp
+	return !r.StopTime.IsZero() && ev.Header.Timestamp != 0 &&
+		!time.Unix(int64(ev.Header.Timestamp), 0).Before(r.StopTime)
+}
+
+func (r *ReplayRange) includesGTID(gtid string) (bool, error) {
+	if gtid == "" {
+		// servers without GTIDs
+		return r.IncludeGTIDs == nil, nil
+	}
+	set, err := gomysql.ParseGTIDSet(gtidFlavor(gtid), gtid)
+	if err != nil {
+		return false, errors.Wrapf(err, "Could not parse GTID %s", gtid)
+	}
+	if r.IncludeGTIDs != nil && !r.IncludeGTIDs.Contain(set) {
+		return false, nil
+	}
+	return r.ExcludeGTIDs == nil || !r.ExcludeGTIDs.Contain(set), nil
+}
+
+// Allows returns false for the row events and DDL statements of the transactions out of range.
+func (r *ReplayRange) Allows(ev *replication.BinlogEvent) (bool, error) {
+	switch e := ev.Event.(type) {
+	case *replication.MariadbGTIDEvent:
+		included, err := r.includesGTID(e.GTID.String())
+		r.skipping = !included
+		return true, err
+
+	case *replication.GTIDEvent:
+		gtid := ""
+		if ev.Header.EventType != replication.ANONYMOUS_GTID_EVENT {
+			var err error
+			if gtid, err = formatGTID(e); err != nil {
+				return false, err
+			}
+		}
+		included, err := r.includesGTID(gtid)
+		r.skipping = !included
+		return true, err
+
+	case *replication.QueryEvent:
+		if query := string(e.Query); query == "BEGIN" || query == "COMMIT" {
+			return true, nil
+		}
+		return r.inRange(ev), nil
+
+	case *replication.TableMapEvent, *replication.RowsEvent:
+		return r.inRange(ev), nil
+	}
+	return true, nil
+}
+
+func (r *ReplayRange) inRange(ev *replication.BinlogEvent) bool {
+	if r.skipping {
+		return false
+	}
+	return r.StartTime.IsZero() || !time.Unix(int64(ev.Header.Timestamp), 0).Before(r.StartTime)
+}
+
+// ReplayStart returns the position the replay of files starts from, after the executed gtidSet
+// if the stream should keep track of GTIDs.
+func ReplayStart(files []string, r *ReplayRange, gtidSet string) Position {
+	pos := r.StartPosition
+	if pos < MinPosition {
+		pos = MinPosition
+	}
+	return Position{GTIDSet: gtidSet, File: filepath.Base(files[0]), Pos: pos}
+}
+
+// ReplayFiles parses binlog files of a server of flavor in order, and calls onEvent with the
+// events in range r until the stop position or time, or an error of onEvent.
+func ReplayFiles(files []string, flavor string, r *ReplayRange, onEvent func(*replication.BinlogEvent) error) error {
+	parser := replication.NewBinlogParser()
+	parser.SetFlavor(flavor)
+	parser.SetTimestampStringLocation(time.UTC)
+
+	for i, file := range files {
+		var offset int64
+		if i == 0 {
+			offset = int64(r.StartPosition)
+		}
+		last := i == len(files)-1
+		err := parser.ParseFile(file, offset, func(ev *replication.BinlogEvent) error {
+			if r.stops(ev, last) {
+				return errStopReplay
+			}
+			allowed, err := r.Allows(ev)
+			if err != nil || !allowed {
+				return err
+			}
+			return onEvent(ev)
+		})
+		// the parser wraps the errors of onEvent
+		if errors.Cause(err) == errStopReplay {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Could not replay %s", file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package binlog
+
+import (
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReplayRange(t *testing.T) {
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	exclude, err := gomysql.ParseGTIDSet(gomysql.MySQLFlavor, testUUID+":2")
+	require.Nil(t, err)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := &ReplayRange{StartTime: start, StopTime: start.Add(time.Hour), ExcludeGTIDs: exclude}
+
+	at := func(t time.Time) *replication.EventHeader {
+		return &replication.EventHeader{Timestamp: uint32(t.Unix())}
+	}
+	gtid := func(gno int64) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.GTID_EVENT},
+			Event: &replication.GTIDEvent{SID: sid, GNO: gno}}
+	}
+	rows := func(t time.Time) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: at(t), Event: &replication.RowsEvent{}}
+	}
+	xid := &replication.BinlogEvent{Header: at(start), Event: &replication.XIDEvent{}}
+
+	for _, c := range []struct {
+		ev      *replication.BinlogEvent
+		allowed bool
+	}{
+		{gtid(1), true},
+		{rows(start.Add(-time.Second)), false},
+		{xid, true},
+		{gtid(2), true},
+		{rows(start), false},
+		{xid, true},
+		{gtid(3), true},
+		{rows(start), true},
+		{&replication.BinlogEvent{Header: at(start), Event: &replication.QueryEvent{Query: []byte("ALTER TABLE t ADD c int")}}, true},
+	} {
+		allowed, err := r.Allows(c.ev)
+		require.Nil(t, err)
+		assert.Equal(t, c.allowed, allowed)
+	}
+
+	assert.False(t, r.stops(rows(start), true))
+	assert.True(t, r.stops(rows(start.Add(time.Hour)), true))
+	r.StopPosition = 1000
+	assert.True(t, r.stops(&replication.BinlogEvent{Header: &replication.EventHeader{LogPos: 1100, EventSize: 100}}, true))
+	assert.False(t, r.stops(&replication.BinlogEvent{Header: &replication.EventHeader{LogPos: 1100, EventSize: 100}}, false))
+	assert.False(t, r.stops(&replication.BinlogEvent{Header: &replication.EventHeader{LogPos: 1050, EventSize: 100}}, true))
+	assert.False(t, r.stops(&replication.BinlogEvent{Header: &replication.EventHeader{LogPos: 0, EventSize: 100}}, true))
+}
@@ -0,0 +1,207 @@
+package binlog
+
+import (
+	"context"
+	"fmt"
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"time"
+)
+
+// MinPosition is the position of the first event of a binlog file, after its magic number
+const MinPosition = 4
+
+// ErrRestarted is returned by Streamer.GetEvent once the stream has been restarted after an error.
+// It restarts from the last committed position, so the events of the transaction that was in
+// progress are sent again, and whatever was collected from them must be discarded.
+var ErrRestarted = errors.New("Binlog stream restarted from the last committed position")
+
+// PurgedError means the server doesn't have the binlog events of Position anymore.
+// Replication can't resume from there, the tables have to be snapshotted again.
+type PurgedError struct {
+	Position Position
+	Err      error
+}
+
+func (e *PurgedError) Error() string {
+	return fmt.Sprintf("The binlog at %s is not available on the server anymore: %s", e.Position, e.Err)
+}
+
+func (e *PurgedError) Unwrap() error {
+	return e.Err
+}
+
+func (p Position) String() string {
+	if p.GTIDSet != "" {
+		return p.GTIDSet
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Pos)
+}
+
+type StreamerSettings struct {
+	Flavor   string
+	Host     string
+	Port     uint16
+	User     string
+	Password string
+	// ServerID identifies the stream as a replica, it must be unique among the replicas of the server
+	ServerID uint32
+	// HeartbeatPeriod is how often the server sends a heartbeat when there are no events.
+	// A connection that stays silent for 3 periods is considered broken.
+	HeartbeatPeriod time.Duration
+	// MinBackoff is the delay before the first reconnection, it doubles up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Streamer reads the binlog from a position, and restarts from the last committed position
+// with exponential backoff when the connection fails.
+type Streamer struct {
+	settings StreamerSettings
+	position Position
+
+	syncer    *replication.BinlogSyncer
+	stream    *replication.BinlogStreamer
+	failures  int
+	retryAt   time.Time
+	restarted bool
+}
+
+func NewStreamer(settings StreamerSettings, start Position) *Streamer {
+	return &Streamer{settings: settings, position: start}
+}
+
+// SetPosition records the position of the last committed transaction, where the stream
+// restarts after an error.
+func (s *Streamer) SetPosition(position Position) {
+	s.position = position
+}
+
+func (s *Streamer) start() error {
+	cfg := replication.BinlogSyncerConfig{
+		ServerID:                s.settings.ServerID,
+		Flavor:                  s.settings.Flavor,
+		Host:                    s.settings.Host,
+		Port:                    s.settings.Port,
+		User:                    s.settings.User,
+		Password:                s.settings.Password,
+		TimestampStringLocation: time.UTC,
+		HeartbeatPeriod:         s.settings.HeartbeatPeriod,
+		ReadTimeout:             3 * s.settings.HeartbeatPeriod,
+		// errors are handled here, so that the position is ours
+		DisableRetrySync: true,
+	}
+
+	var err error
+	if s.position.GTIDSet == "" && s.position.File != "" {
+		pos := s.position.Pos
+		if pos < MinPosition {
+			pos = MinPosition
+		}
+		s.syncer = replication.NewBinlogSyncer(cfg)
+		s.stream, err = s.syncer.StartSync(gomysql.Position{Name: s.position.File, Pos: pos})
+	} else {
+		var gtidSet gomysql.GTIDSet
+		gtidSet, err = gomysql.ParseGTIDSet(s.settings.Flavor, s.position.GTIDSet)
+		if err != nil {
+			return errors.Wrapf(err, "Could not parse GTID set %s", s.position.GTIDSet)
+		}
+		s.syncer = replication.NewBinlogSyncer(cfg)
+		s.stream, err = s.syncer.StartSyncGTID(gtidSet)
+	}
+	if err != nil {
+		s.Close()
+		return errors.Wrap(err, "Could not start binlog sync")
+	}
+	return nil
+}
+
+// isPurged returns true for the error the server sends when it can't find the requested
+// position in its binlog files.
+func isPurged(err error) bool {
+	var myErr *gomysql.MyError
+	if !errors.As(err, &myErr) || myErr.Code != gomysql.ER_MASTER_FATAL_ERROR_READING_BINLOG {
+		return false
+	}
+	message := strings.ToLower(myErr.Message)
+	for _, s := range []string{"purged", "could not find", "first log file name", "required binlog files"} {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before reconnecting after consecutive failures.
+func (s *Streamer) backoff(failures int) time.Duration {
+	backoff := s.settings.MinBackoff
+	for i := 1; i < failures && backoff < s.settings.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.settings.MaxBackoff {
+		backoff = s.settings.MaxBackoff
+	}
+	return backoff
+}
+
+func (s *Streamer) fail(err error) {
+	s.Close()
+	s.failures++
+	backoff := s.backoff(s.failures)
+	s.retryAt = time.Now().Add(backoff)
+	log.Warn().Err(err).Int("failures", s.failures).Dur("backoff", backoff).
+		Str("position", s.position.String()).Msg("Binlog stream failed, restarting")
+}
+
+// GetEvent returns the next event. Connection errors are retried until ctx is done, in which
+// case ctx.Err() is returned. ErrRestarted is returned after a restart, and a *PurgedError
+// if the position is not in the binlog anymore.
+func (s *Streamer) GetEvent(ctx context.Context) (*replication.BinlogEvent, error) {
+	for {
+		if s.stream == nil {
+			// the retry time is kept across calls, ctx can be shorter than the backoff
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Until(s.retryAt)):
+			}
+			if err := s.start(); err != nil {
+				if isPurged(err) {
+					return nil, &PurgedError{Position: s.position, Err: err}
+				}
+				s.fail(err)
+				continue
+			}
+			if s.restarted {
+				s.restarted = false
+				return nil, ErrRestarted
+			}
+		}
+
+		ev, err := s.stream.GetEvent(ctx)
+		if err == nil {
+			s.failures = 0
+			return ev, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if isPurged(err) {
+			s.Close()
+			return nil, &PurgedError{Position: s.position, Err: err}
+		}
+		s.fail(err)
+		s.restarted = true
+	}
+}
+
+func (s *Streamer) Close() {
+	if s.syncer != nil {
+		s.syncer.Close()
+	}
+	s.syncer = nil
+	s.stream = nil
+}
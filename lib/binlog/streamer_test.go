@@ -0,0 +1,52 @@
+package binlog
+
+import (
+	"context"
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStreamerBackoff(t *testing.T) {
+	s := NewStreamer(StreamerSettings{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}, Position{})
+	assert.Equal(t, time.Second, s.backoff(1))
+	assert.Equal(t, 2*time.Second, s.backoff(2))
+	assert.Equal(t, 8*time.Second, s.backoff(4))
+	assert.Equal(t, 10*time.Second, s.backoff(5))
+	assert.Equal(t, 10*time.Second, s.backoff(100))
+}
+
+func TestIsPurged(t *testing.T) {
+	purged := &gomysql.MyError{
+		Code: gomysql.ER_MASTER_FATAL_ERROR_READING_BINLOG,
+		Message: "Cannot replicate because the master purged required binary logs. " +
+			"Replicate the missing transactions from elsewhere, or provision a new slave from backup.",
+	}
+	assert.True(t, isPurged(purged))
+	assert.True(t, isPurged(errors.Wrap(purged, "Could not start binlog sync")))
+	assert.False(t, isPurged(&gomysql.MyError{Code: gomysql.ER_MASTER_FATAL_ERROR_READING_BINLOG,
+		Message: "binlog truncated in the middle of event"}))
+	assert.False(t, isPurged(errors.New("connection reset by peer")))
+}
+
+func TestStreamerBacksOffWhenStartFails(t *testing.T) {
+	// nothing listens on port 1, every start fails
+	s := NewStreamer(StreamerSettings{
+		Flavor:          "mysql",
+		Host:            "127.0.0.1",
+		Port:            1,
+		ServerID:        100,
+		HeartbeatPeriod: time.Second,
+		MinBackoff:      10 * time.Millisecond,
+		MaxBackoff:      20 * time.Millisecond,
+	}, Position{GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := s.GetEvent(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Greater(t, s.failures, 1)
+	assert.Nil(t, s.stream)
+}
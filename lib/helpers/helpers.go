@@ -3,6 +3,7 @@ package helpers
 import (
 	"fmt"
 	"github.com/spf13/viper"
+	"majipoor/lib/psql"
 )
 
 func GetRootMysqlConnectionString() string {
@@ -22,3 +23,39 @@ func GetReplicaMysqlConnectionString() string {
 		viper.GetInt("mysql.port"),
 		viper.GetString("mysql.database"))
 }
+
+func GetPsqlConnectionString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		viper.GetString("postgresql.host"),
+		viper.GetInt("postgresql.port"),
+		viper.GetString("postgresql.username"),
+		viper.GetString("postgresql.password"),
+		viper.GetString("postgresql.db"),
+		viper.GetString("postgresql.sslmode"))
+}
+
+// GetRootPsqlConnectionString connects as the postgresql root user to database,
+// for the statements that can't run on the replica database itself.
+func GetRootPsqlConnectionString(database string) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		viper.GetString("postgresql.host"),
+		viper.GetInt("postgresql.port"),
+		viper.GetString("postgresql.root-username"),
+		viper.GetString("postgresql.root-password"),
+		database,
+		viper.GetString("postgresql.sslmode"))
+}
+
+// GetMappingSettings returns the mapping settings, which the snapshot, the applier and
+// create-schema have to share.
+func GetMappingSettings() (psql.MappingSettings, error) {
+	settings, err := psql.ParseMappingSettings(viper.GetString("postgresql.type-mapping"),
+		viper.GetString("postgresql.keyless-strategy"), viper.GetString("postgresql.table-mode"),
+		viper.GetStringSlice("postgresql.table-modes"), viper.GetBool("postgresql.metadata-columns"),
+		viper.GetString("postgresql.metadata-schema"))
+	if err != nil {
+		return settings, err
+	}
+	settings.Indexes = viper.GetBool("postgresql.indexes")
+	return settings, nil
+}
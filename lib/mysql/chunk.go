@@ -0,0 +1,198 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Big tables are snapshotted in chunks, ranges of the values of a key of the table, the
+// primary key if there is one, or else a unique index over NOT NULL columns.
+// Chunks are read with keyset paging, so that each of them is a range scan of the key.
+
+// GetChunkKey returns the index used to split a table into chunks: the primary key,
+// or else the first unique index without NULL columns (NULLs can't be paged through).
+// It returns nil if there is no such index.
+func GetChunkKey(indexes []*IndexMetadata) *IndexMetadata {
+	for _, i := range indexes {
+		if i.IsPrimary() {
+			return i
+		}
+	}
+	for _, i := range indexes {
+		if i.Unique && !i.Nullable && !i.Functional {
+			return i
+		}
+	}
+	return nil
+}
+
+// Chunk is the range of rows whose key is > Lower and <= Upper.
+// A nil bound is open. Bounds are the text values of the key columns
+// (hex encoded for binary columns), so that they can be stored.
+type Chunk struct {
+	Lower []string
+	Upper []string
+}
+
+func getKeyColumns(columns []*ColumnMetadata, key []string) ([]*ColumnMetadata, error) {
+	var keyColumns []*ColumnMetadata
+	for _, k := range key {
+		found := false
+		for _, c := range columns {
+			if c.ColumnName == k {
+				keyColumns = append(keyColumns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("Unknown key column %s", k)
+		}
+	}
+	return keyColumns, nil
+}
+
+func (c *ColumnMetadata) getKeySelectStatement() string {
+	name := QuoteIdentifier(c.ColumnName)
+	if contains(c.DataType, hexTypes) {
+		return fmt.Sprintf("hex(%s)", name)
+	}
+	return fmt.Sprintf("cast(%s AS char CHARACTER SET %s)", name, defaultCharacterSet)
+}
+
+var integerTypes = []string{"tinyint", "smallint", "mediumint", "int", "bigint"}
+
+// getKeyPlaceholder returns the placeholder of a bound of the column. Numbers are cast from
+// their text, mysql would otherwise compare them with the string as doubles, which can't hold
+// every bigint.
+func (c *ColumnMetadata) getKeyPlaceholder() string {
+	switch {
+	case contains(c.DataType, hexTypes):
+		return "unhex(?)"
+	case contains(c.DataType, integerTypes) && c.IsUnsigned():
+		return "CAST(? AS UNSIGNED)"
+	case contains(c.DataType, integerTypes):
+		return "CAST(? AS SIGNED)"
+	case c.DataType == "decimal" && c.NumericPrecision != nil && c.NumericScale != nil:
+		return fmt.Sprintf("CAST(? AS DECIMAL(%d, %d))", *c.NumericPrecision, *c.NumericScale)
+	}
+	return "?"
+}
+
+// getKeyComparison returns a row comparison of the key columns against bound,
+// for example "(`a`, `b`) > (?, ?)"
+func getKeyComparison(keyColumns []*ColumnMetadata, op string, bound []string) (string, []interface{}) {
+	var names, placeholders []string
+	var args []interface{}
+	for i, c := range keyColumns {
+		names = append(names, QuoteIdentifier(c.ColumnName))
+		placeholders = append(placeholders, c.getKeyPlaceholder())
+		args = append(args, bound[i])
+	}
+	return fmt.Sprintf("(%s) %s (%s)", strings.Join(names, ", "), op, strings.Join(placeholders, ", ")), args
+}
+
+func getKeyOrderBy(keyColumns []*ColumnMetadata) string {
+	var names []string
+	for _, c := range keyColumns {
+		names = append(names, QuoteIdentifier(c.ColumnName))
+	}
+	return strings.Join(names, ", ")
+}
+
+// GetChunks splits table into chunks of chunkSize rows along key, as seen by the snapshot.
+// It returns a single open chunk if key is empty.
+func (cs *ConsistentSnapshot) GetChunks(table string, columns []*ColumnMetadata, key []string, chunkSize int) ([]*Chunk, error) {
+	if len(key) == 0 || chunkSize <= 0 {
+		return []*Chunk{{}}, nil
+	}
+	keyColumns, err := getKeyColumns(columns, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var selects []string
+	for _, c := range keyColumns {
+		selects = append(selects, c.getKeySelectStatement())
+	}
+
+	var chunks []*Chunk
+	var lower []string
+	for {
+		sql_ := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), QuoteIdentifier(table))
+		var args []interface{}
+		if lower != nil {
+			where, whereArgs := getKeyComparison(keyColumns, ">", lower)
+			sql_ += " WHERE " + where
+			args = whereArgs
+		}
+		sql_ += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d", getKeyOrderBy(keyColumns), chunkSize-1)
+
+		upper, err := cs.queryKey(sql_, len(keyColumns), args...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not split %s into chunks", table)
+		}
+		chunks = append(chunks, &Chunk{Lower: lower, Upper: upper})
+		if upper == nil {
+			return chunks, nil
+		}
+		lower = upper
+	}
+}
+
+// queryKey returns the key values of the single row returned by sql_, or nil if there is none
+func (cs *ConsistentSnapshot) queryKey(sql_ string, n int, args ...interface{}) ([]string, error) {
+	rows, err := cs.Query(sql_, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	values := make([]sql.NullString, n)
+	dest := make([]interface{}, n)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = rows.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]string, n)
+	for i, v := range values {
+		key[i] = v.String
+	}
+	return key, nil
+}
+
+// GetChunkSelectStatement returns a SELECT like GetSelectStatement, restricted to chunk.
+func GetChunkSelectStatement(table string, columns []*ColumnMetadata, key []string, chunk *Chunk) (string, []interface{}, error) {
+	sql_ := GetSelectStatement(table, columns)
+	if chunk.Lower == nil && chunk.Upper == nil {
+		return sql_, nil, nil
+	}
+	keyColumns, err := getKeyColumns(columns, key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var wheres []string
+	var args []interface{}
+	if chunk.Lower != nil {
+		where, whereArgs := getKeyComparison(keyColumns, ">", chunk.Lower)
+		wheres = append(wheres, where)
+		args = append(args, whereArgs...)
+	}
+	if chunk.Upper != nil {
+		where, whereArgs := getKeyComparison(keyColumns, "<=", chunk.Upper)
+		wheres = append(wheres, where)
+		args = append(args, whereArgs...)
+	}
+	return sql_ + " WHERE " + strings.Join(wheres, " AND "), args, nil
+}
@@ -0,0 +1,33 @@
+package mysql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGetChunkSelectStatement(t *testing.T) {
+	precision, scale := 10, 2
+	columns := []*ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20) unsigned"},
+		{ColumnName: "shard", DataType: "int", ColumnType: "int(11)"},
+		{ColumnName: "price", DataType: "decimal", ColumnType: "decimal(10,2)",
+			NumericPrecision: &precision, NumericScale: &scale},
+		{ColumnName: "hash", DataType: "binary", ColumnType: "binary(16)"},
+		{ColumnName: "name", DataType: "varchar", ColumnType: "varchar(20)"},
+	}
+	key := []string{"id", "shard", "price", "hash", "name"}
+	// snowflake ids are above 2^53, and can't all be told apart as doubles
+	chunk := &Chunk{
+		Lower: []string{"1609459200000000001", "-1", "9.99", "CAFE", "a"},
+		Upper: []string{"18446744073709551615", "2", "10.00", "BEEF", "b"},
+	}
+
+	sql_, args, err := GetChunkSelectStatement("wp_posts", columns, key, chunk)
+	require.NoError(t, err)
+	placeholders := "(CAST(? AS UNSIGNED), CAST(? AS SIGNED), CAST(? AS DECIMAL(10, 2)), unhex(?), ?)"
+	assert.Contains(t, sql_, " WHERE (`id`, `shard`, `price`, `hash`, `name`) > "+placeholders+
+		" AND (`id`, `shard`, `price`, `hash`, `name`) <= "+placeholders)
+	assert.Equal(t, []interface{}{"1609459200000000001", "-1", "9.99", "CAFE", "a",
+		"18446744073709551615", "2", "10.00", "BEEF", "b"}, args)
+}
@@ -0,0 +1,161 @@
+package mysql
+
+import (
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pkg/errors"
+)
+
+// ForeignKeyMetadata is a foreign key of a table, from information_schema.KEY_COLUMN_USAGE
+// and REFERENTIAL_CONSTRAINTS.
+type ForeignKeyMetadata struct {
+	Name              string
+	Columns           []string
+	ReferencedSchema  string
+	ReferencedTable   string
+	ReferencedColumns []string
+	// UpdateRule and DeleteRule are CASCADE, SET NULL, SET DEFAULT, RESTRICT or NO ACTION
+	UpdateRule string
+	DeleteRule string
+}
+
+// CheckMetadata is a CHECK constraint of a table. Clause is the mysql expression.
+type CheckMetadata struct {
+	Name   string
+	Clause string
+}
+
+// TableConstraints are the foreign keys and CHECK constraints of a table. Mysql doesn't
+// always enforce them: foreign keys are ignored with foreign_key_checks=0 and by other
+// engines than InnoDB, and CHECK constraints are only enforced since mysql 8.0.16.
+type TableConstraints struct {
+	ForeignKeys []*ForeignKeyMetadata
+	Checks      []*CheckMetadata
+}
+
+// GetConstraints returns the foreign keys and the CHECK constraints of table.
+func (md *MysqlDB) GetConstraints(schema string, table string) (*TableConstraints, error) {
+	foreignKeys, err := md.getForeignKeys(schema, table)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get foreign keys of %s", table)
+	}
+	checks, err := md.getChecks(schema, table)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get check constraints of %s", table)
+	}
+	return &TableConstraints{ForeignKeys: foreignKeys, Checks: checks}, nil
+}
+
+func (md *MysqlDB) getForeignKeys(schema string, table string) ([]*ForeignKeyMetadata, error) {
+	sb := sqlbuilder.Select("k.CONSTRAINT_NAME", "k.COLUMN_NAME", "k.REFERENCED_TABLE_SCHEMA",
+		"k.REFERENCED_TABLE_NAME", "k.REFERENCED_COLUMN_NAME", "r.UPDATE_RULE", "r.DELETE_RULE").
+		From("information_schema.KEY_COLUMN_USAGE k").
+		Join("information_schema.REFERENTIAL_CONSTRAINTS r",
+			"r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA",
+			"r.CONSTRAINT_NAME = k.CONSTRAINT_NAME",
+			"r.TABLE_NAME = k.TABLE_NAME")
+	sb.Where(sb.Equal("k.TABLE_SCHEMA", schema))
+	sb.Where(sb.Equal("k.TABLE_NAME", table))
+	sb.Where(sb.IsNotNull("k.REFERENCED_TABLE_NAME"))
+	sb.OrderBy("k.CONSTRAINT_NAME", "k.ORDINAL_POSITION")
+	sql_, args := sb.Build()
+
+	rows, err := md.Db.Query(sql_, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var foreignKeys []*ForeignKeyMetadata
+	var current *ForeignKeyMetadata
+	for rows.Next() {
+		var name, column, referencedSchema, referencedTable, referencedColumn, updateRule, deleteRule string
+		err = rows.Scan(&name, &column, &referencedSchema, &referencedTable, &referencedColumn, &updateRule, &deleteRule)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.Name != name {
+			current = &ForeignKeyMetadata{
+				Name:             name,
+				ReferencedSchema: referencedSchema,
+				ReferencedTable:  referencedTable,
+				UpdateRule:       updateRule,
+				DeleteRule:       deleteRule,
+			}
+			foreignKeys = append(foreignKeys, current)
+		}
+		current.Columns = append(current.Columns, column)
+		current.ReferencedColumns = append(current.ReferencedColumns, referencedColumn)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return foreignKeys, nil
+}
+
+// getChecks returns the CHECK constraints of table, nil if the server has no
+// information_schema.CHECK_CONSTRAINTS (mysql before 8.0.16, mariadb before 10.2).
+func (md *MysqlDB) getChecks(schema string, table string) ([]*CheckMetadata, error) {
+	version, err := md.GetServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	if (version.IsMariaDB() && !version.AtLeast(10, 2)) || (!version.IsMariaDB() && !version.AtLeast(8, 0)) {
+		return nil, nil
+	}
+
+	onExprs := []string{
+		"c.CONSTRAINT_SCHEMA = t.CONSTRAINT_SCHEMA",
+		"c.CONSTRAINT_NAME = t.CONSTRAINT_NAME",
+	}
+	// mariadb names constraints per table, mysql per schema
+	if version.IsMariaDB() {
+		onExprs = append(onExprs, "c.TABLE_NAME = t.TABLE_NAME")
+	}
+	sb := sqlbuilder.Select("c.CONSTRAINT_NAME", "c.CHECK_CLAUSE").
+		From("information_schema.TABLE_CONSTRAINTS t").
+		Join("information_schema.CHECK_CONSTRAINTS c", onExprs...)
+	sb.Where(sb.Equal("t.TABLE_SCHEMA", schema))
+	sb.Where(sb.Equal("t.TABLE_NAME", table))
+	sb.Where(sb.Equal("t.CONSTRAINT_TYPE", "CHECK"))
+	sb.OrderBy("c.CONSTRAINT_NAME")
+	sql_, args := sb.Build()
+
+	rows, err := md.Db.Query(sql_, args...)
+	if err != nil {
+		// mysql 8.0 only has the table since 8.0.16
+		if isUnknownTable(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var checks []*CheckMetadata
+	for rows.Next() {
+		var check CheckMetadata
+		err = rows.Scan(&check.Name, &check.Clause)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, &check)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// erUnknownTable is the error of a query on a table information_schema doesn't have.
+const erUnknownTable = 1109
+
+func isUnknownTable(err error) bool {
+	var mysqlErr *driver.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == erUnknownTable
+}
@@ -0,0 +1,244 @@
+package mysql
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"majipoor/lib/mysql/grammar"
+	"strconv"
+	"strings"
+)
+
+// ColumnMetadataFromDefinition returns the metadata information_schema would have for a column
+// defined in a CREATE TABLE or ALTER TABLE statement. The ordinal position is left to the caller.
+func ColumnMetadataFromDefinition(def *grammar.ColumnDefinition) (*ColumnMetadata, error) {
+	c := &ColumnMetadata{IsNullable: "YES"}
+
+	var dataType grammar.ColumnDataType
+	var notNull, primaryKey, uniqueKey bool
+	var extras []string
+	switch {
+	case def.Simple != nil:
+		s := def.Simple
+		c.ColumnName = s.ColumnName
+		dataType = s.DataType
+		notNull, primaryKey, uniqueKey = s.NotNull, s.PrimaryKey, s.UniqueKey
+
+		if s.Default != nil {
+			value, generated, err := getDefaultValue(s.Default)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not get default of column %s", c.ColumnName)
+			}
+			c.ColumnDefault = value
+			if generated {
+				extras = append(extras, "DEFAULT_GENERATED")
+			}
+		}
+		if s.AutoIncrement {
+			extras = append(extras, "auto_increment")
+		}
+		if s.OnUpdateCurrentTimestamp {
+			extras = append(extras, "on update CURRENT_TIMESTAMP")
+		}
+
+	case def.AsColumn != nil:
+		a := def.AsColumn
+		c.ColumnName = a.ColumnName
+		dataType = a.DataType
+		notNull, primaryKey, uniqueKey = a.NotNull, a.PrimaryKey, a.UniqueKey
+		if a.IsStored {
+			extras = append(extras, "STORED GENERATED")
+		} else {
+			extras = append(extras, "VIRTUAL GENERATED")
+		}
+
+	default:
+		return nil, errors.New("Empty column definition")
+	}
+
+	if err := c.setType(dataType); err != nil {
+		return nil, errors.Wrapf(err, "Could not get type of column %s", c.ColumnName)
+	}
+	if notNull || primaryKey {
+		c.IsNullable = "NO"
+	}
+	switch {
+	case primaryKey:
+		c.ColumnKey = "PRI"
+	case uniqueKey:
+		c.ColumnKey = "UNI"
+	}
+	c.Extra = strings.Join(extras, " ")
+
+	return c, nil
+}
+
+// IsDefaultGenerated returns true if the default is an expression (CURRENT_TIMESTAMP) rather than a value.
+func (c *ColumnMetadata) IsDefaultGenerated() bool {
+	return strings.Contains(c.Extra, "DEFAULT_GENERATED")
+}
+
+// IsGenerated returns true for generated columns, whose values are computed by mysql.
+func (c *ColumnMetadata) IsGenerated() bool {
+	return strings.Contains(c.Extra, "STORED GENERATED") || strings.Contains(c.Extra, "VIRTUAL GENERATED")
+}
+
+func (c *ColumnMetadata) IsAutoIncrement() bool {
+	return strings.Contains(c.Extra, "auto_increment")
+}
+
+// getDefaultValue returns the default the way information_schema shows it: nil for NULL,
+// and whether it is generated.
+func getDefaultValue(d *grammar.ColumnDefault) (*string, bool, error) {
+	var s string
+	switch {
+	case d.Null:
+		return nil, false, nil
+	case d.Number != nil:
+		s = strconv.FormatFloat(*d.Number, 'f', -1, 64)
+	case d.String != nil:
+		s = *d.String
+	case d.Boolean != nil:
+		s = "0"
+		if *d.Boolean {
+			s = "1"
+		}
+	case d.CurrentTimestamp:
+		return &s, true, nil
+	default:
+		return nil, false, errors.New("Unsupported default expression")
+	}
+	return &s, false, nil
+}
+
+func withLength(name string, length *int) string {
+	if length == nil {
+		return name
+	}
+	return fmt.Sprintf("%s(%d)", name, *length)
+}
+
+func withSign(columnType string, unsigned bool, zerofill bool) string {
+	if unsigned || zerofill {
+		columnType += " unsigned"
+	}
+	if zerofill {
+		columnType += " zerofill"
+	}
+	return columnType
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func (c *ColumnMetadata) setType(dt grammar.ColumnDataType) error {
+	switch {
+	case dt.Bit != nil:
+		c.DataType = "bit"
+		length := dt.Bit.Precision
+		if length == nil {
+			length = intPtr(1)
+		}
+		c.ColumnType = withLength(c.DataType, length)
+		c.NumericPrecision = length
+
+	case dt.Integer != nil:
+		c.DataType = strings.ToLower(string(*dt.Integer.Type))
+		if c.DataType == "integer" {
+			c.DataType = "int"
+		}
+		c.ColumnType = withSign(withLength(c.DataType, dt.Integer.Precision), dt.Integer.Unsigned, dt.Integer.Zerofill)
+
+	case dt.Bool:
+		c.DataType = "tinyint"
+		c.ColumnType = "tinyint(1)"
+
+	case dt.Decimal != nil:
+		c.DataType = "decimal"
+		precision, scale := 10, 0
+		if dt.Decimal.Precision != nil {
+			precision = *dt.Decimal.Precision
+		}
+		if dt.Decimal.Scale != nil {
+			scale = *dt.Decimal.Scale
+		}
+		c.NumericPrecision, c.NumericScale = &precision, &scale
+		c.ColumnType = withSign(fmt.Sprintf("decimal(%d,%d)", precision, scale), dt.Decimal.Unsigned, dt.Decimal.Zerofill)
+
+	case dt.Float != nil:
+		c.DataType = "double"
+		// FLOAT(p) is a double above 24 bits of precision
+		if *dt.Float.Type == "FLOAT" && (dt.Float.Precision == nil || dt.Float.Scale != nil || *dt.Float.Precision <= 24) {
+			c.DataType = "float"
+		}
+		c.ColumnType = c.DataType
+		if dt.Float.Precision != nil && dt.Float.Scale != nil {
+			c.ColumnType = fmt.Sprintf("%s(%d,%d)", c.DataType, *dt.Float.Precision, *dt.Float.Scale)
+		}
+		c.ColumnType = withSign(c.ColumnType, dt.Float.Unsigned, dt.Float.Zerofill)
+
+	case dt.Date != nil:
+		c.DataType = strings.ToLower(string(*dt.Date.Type))
+		c.ColumnType = withLength(c.DataType, dt.Date.Precision)
+
+	case dt.String != nil:
+		c.DataType = strings.ToLower(string(*dt.String.Type))
+		length := dt.String.Precision
+		if c.DataType == "char" && length == nil {
+			length = intPtr(1)
+		}
+		if c.DataType == "char" || c.DataType == "varchar" {
+			if length == nil {
+				return errors.New("VARCHAR without length")
+			}
+			c.CharacterMaximumLength = length
+			c.ColumnType = withLength(c.DataType, length)
+		} else {
+			c.ColumnType = c.DataType
+		}
+
+	case dt.EnumSet != nil:
+		c.DataType = "enum"
+		if dt.EnumSet.IsSet {
+			c.DataType = "set"
+		}
+		var values []string
+		for _, v := range dt.EnumSet.Values {
+			values = append(values, "'"+strings.ReplaceAll(v, "'", "''")+"'")
+		}
+		list := "(" + strings.Join(values, ",") + ")"
+		c.ColumnType = c.DataType + list
+		if c.DataType == "enum" {
+			c.EnumList = &list
+		}
+
+	case dt.Blob != nil:
+		c.DataType = strings.ToLower(string(*dt.Blob.Type))
+		length := dt.Blob.Precision
+		if c.DataType == "binary" && length == nil {
+			length = intPtr(1)
+		}
+		if c.DataType == "binary" || c.DataType == "varbinary" {
+			if length == nil {
+				return errors.New("VARBINARY without length")
+			}
+			c.CharacterMaximumLength = length
+			c.ColumnType = withLength(c.DataType, length)
+		} else {
+			c.ColumnType = c.DataType
+		}
+
+	case dt.Spatial != nil:
+		c.DataType = strings.ToLower(string(*dt.Spatial))
+		c.ColumnType = c.DataType
+
+	case dt.Json:
+		c.DataType = "json"
+		c.ColumnType = "json"
+
+	default:
+		return errors.New("Unknown data type")
+	}
+
+	return nil
+}
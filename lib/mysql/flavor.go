@@ -0,0 +1,57 @@
+package mysql
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Flavors have the names go-mysql uses for GTID parsing and binlog syncing.
+const (
+	MySQLFlavor   = "mysql"
+	MariaDBFlavor = "mariadb"
+)
+
+// ServerVersion is the flavor and version of the server, as returned by VERSION()
+// (for example "8.0.33" or "10.6.12-MariaDB-1:10.6.12+maria~ubu2004-log").
+type ServerVersion struct {
+	Version string
+	Flavor  string
+	Major   int
+	Minor   int
+}
+
+var versionRegexp = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+func ParseServerVersion(version string) (*ServerVersion, error) {
+	matches := versionRegexp.FindStringSubmatch(version)
+	if matches == nil {
+		return nil, errors.Errorf("Could not parse server version %s", version)
+	}
+	v := &ServerVersion{Version: version, Flavor: MySQLFlavor}
+	v.Major, _ = strconv.Atoi(matches[1])
+	v.Minor, _ = strconv.Atoi(matches[2])
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		v.Flavor = MariaDBFlavor
+	}
+	return v, nil
+}
+
+func (v *ServerVersion) IsMariaDB() bool {
+	return v.Flavor == MariaDBFlavor
+}
+
+// AtLeast returns true if the version is major.minor or later.
+func (v *ServerVersion) AtLeast(major int, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func (md *MysqlDB) GetServerVersion() (*ServerVersion, error) {
+	var version string
+	err := md.Db.QueryRow("SELECT VERSION()").Scan(&version)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get server version")
+	}
+	return ParseServerVersion(version)
+}
@@ -0,0 +1,33 @@
+package mysql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	v, err := ParseServerVersion("8.0.33")
+	require.NoError(t, err)
+	assert.Equal(t, MySQLFlavor, v.Flavor)
+	assert.Equal(t, 8, v.Major)
+	assert.Equal(t, 0, v.Minor)
+	assert.True(t, v.AtLeast(5, 7))
+	assert.True(t, v.AtLeast(8, 0))
+	assert.False(t, v.AtLeast(8, 1))
+
+	v, err = ParseServerVersion("10.6.12-MariaDB-1:10.6.12+maria~ubu2004-log")
+	require.NoError(t, err)
+	assert.True(t, v.IsMariaDB())
+	assert.Equal(t, 10, v.Major)
+	assert.Equal(t, 6, v.Minor)
+	assert.False(t, v.AtLeast(10, 10))
+
+	v, err = ParseServerVersion("5.7.42-log")
+	require.NoError(t, err)
+	assert.False(t, v.IsMariaDB())
+	assert.False(t, v.AtLeast(8, 0))
+
+	_, err = ParseServerVersion("unknown")
+	assert.Error(t, err)
+}
@@ -1,14 +1,13 @@
 package grammar
 
 type AlterTable struct {
-	Name             string                 `"ALTER" "TABLE" `
-	AlterOptions     []AlterOption          ` @@ ( "," @@ )*`
-	PartitionOptions []AlterPartitionOption `@@ ( "," @@ )*`
+	Name         string         `"ALTER" "TABLE" @( Ident ( "." Ident )* )`
+	AlterOptions []*AlterOption `@@ ( "," @@ )*`
 }
 
 type AlterOption struct {
-	AddSingleColumn              *AddSingleColumn           `( "ADD" "COLUMN"? @@`
-	AddMultipleColumns           []AddColumnDefinition      `| "ADD" "COLUMN"? "(" @@ ( "," @@ )* ")"`
+	AddMultipleColumns           []*ColumnDefinition        `( "ADD" "COLUMN"? "(" @@ ( "," @@ )* ")"`
+	AddSingleColumn              *AddSingleColumn           `| "ADD" "COLUMN"? @@`
 	AddSimpleIndex               *SimpleIndexDefinition     `| "ADD" ( "INDEX" | "KEY" ) @@`
 	AddPrimaryKeyDefinition      *PrimaryKeyDefinition      `| "ADD" @@`
 	AddUniqueKeyDefinition       *UniqueKeyDefinition       `| "ADD" @@`
@@ -17,23 +16,53 @@ type AlterOption struct {
 	AddCheckConstraintDefinition *CheckConstraintDefinition `| "ADD" @@`
 
 	DropCheckConstraint  *string               `| "DROP" ( "CHECK" | "CONSTRAINT") @Ident`
+	DropIndex            *string               `| "DROP" ( "INDEX" | "KEY" ) @Ident`
+	DropPrimaryKey       bool                  `| @( "DROP" "PRIMARY" "KEY" )`
+	DropForeignKey       *string               `| "DROP" "FOREIGN" "KEY" @Ident`
+	DropColumn           *string               `| "DROP" "COLUMN"? @Ident`
 	AlterCheckConstraint *AlterCheckConstraint `| "ALTER" ( "CHECK" | "CONSTRAINT" ) @@`
+	AlterColumn          *AlterColumn          `| "ALTER" "COLUMN"? @@`
+	ModifyColumn         *AddSingleColumn      `| "MODIFY" "COLUMN"? @@`
+	ChangeColumn         *ChangeColumn         `| "CHANGE" "COLUMN"? @@`
+	RenameColumn         *RenameColumn         `| "RENAME" "COLUMN" @@`
+	RenameIndex          *RenameColumn         `| "RENAME" ( "INDEX" | "KEY" ) @@`
+	RenameTable          *string               `| "RENAME" ( "TO" | "AS" )? @( Ident ( "." Ident )* )`
+	ConvertCharacterSet  *ConvertCharacterSet  `| "CONVERT" "TO" @@`
 	Algorithm            *string               `| "ALGORITHM" "="? @( "DEFAULT" | "INSTANT" | "INPLACE" | "COPY" )`
+	Lock                 *string               `| "LOCK" "="? @( "DEFAULT" | "NONE" | "SHARED" | "EXCLUSIVE" )`
+	TableOption          *TableOption          `| @@ )`
 }
 
+// AddSingleColumn is used by ADD COLUMN and MODIFY COLUMN
 type AddSingleColumn struct {
-	Name             string           `@Ident`
-	ColumnDefinition ColumnDefinition `@@`
-	IsFirst          bool             `@"FIRST"?`
-	After            *string          `( "AFTER" @Ident )?`
+	ColumnDefinition *ColumnDefinition `@@`
+	IsFirst          bool              `( @"FIRST"`
+	After            *string           `| "AFTER" @Ident )?`
 }
 
-type AddColumnDefinition struct {
-	Name             string           `@Ident`
-	ColumnDefinition ColumnDefinition `@@`
+type ChangeColumn struct {
+	OldName          string            `@Ident`
+	ColumnDefinition *ColumnDefinition `@@`
+	IsFirst          bool              `( @"FIRST"`
+	After            *string           `| "AFTER" @Ident )?`
 }
 
-type AlterPartitionOption struct {
+type RenameColumn struct {
+	OldName string `@Ident`
+	NewName string `"TO" @Ident`
+}
+
+type AlterColumn struct {
+	Name         string         `@Ident`
+	SetDefault   *ColumnDefault `( "SET" "DEFAULT" ( @@ | "(" @@ ")" )`
+	DropDefault  bool           `| @( "DROP" "DEFAULT" )`
+	SetVisible   bool           `| "SET" @"VISIBLE"`
+	SetInvisible bool           `| "SET" @"INVISIBLE" )`
+}
+
+type ConvertCharacterSet struct {
+	CharacterSet string  `( "CHARACTER" "SET" | "CHARSET" ) @Ident`
+	Collation    *string `( "COLLATE" @Ident )?`
 }
 
 type AlterCheckConstraint struct {
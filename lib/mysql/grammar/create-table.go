@@ -4,8 +4,8 @@ type TableName string
 
 type CreateTable struct {
 	Temporary        bool                     `"CREATE" ( @"TEMPORARY" )?  "TABLE"`
-	Name             string                   `@( Ident ( "." Ident )* )`
 	IfNotExists      bool                     `@( "IF" "NOT" "EXISTS" )?`
+	Name             string                   `@( Ident ( "." Ident )* )`
 	CreateDefinition []*CreateTableDefinition `"(" ( @@ ( "," @@ )* )? ")"`
 	TableOptions     []TableOption            `@@*`
 	PartitionOptions *PartitionOptions        ` ( "PARTITION" "BY" @@ )?`
@@ -25,7 +25,7 @@ type TableOption struct {
 	AutoExtendSize           *int        `( "AUTOEXTEND_SIZE" "="? @Number`
 	AutoIncrement            *int        ` | "AUTO_INCREMENT" "="? @Number`
 	AvgRowLength             *int        ` | "AVG_ROW_LENGTH" "="? @Number`
-	CharacterSet             *string     ` | "DEFAULT"? "CHARACTER" "SET" "="? @Ident`
+	CharacterSet             *string     ` | "DEFAULT"? ( "CHARACTER" "SET" | "CHARSET" ) "="? @Ident`
 	Checksum                 *int        ` | "CHECKSUM" "="? @Number`
 	Collation                *string     ` | "DEFAULT"? "COLLATE" "="? @Ident`
 	Comment                  *string     ` | "COMMENT" "="? @String`
@@ -112,6 +112,7 @@ type SimpleColumnDefinition struct {
 	DataType                  ColumnDataType             `@@`
 	NotNull                   bool                       `( @( "NOT" "NULL" ) | "NULL" )?`
 	Default                   *ColumnDefault             `( "DEFAULT" @@ )?`
+	OnUpdateCurrentTimestamp  bool                       `( "ON" "UPDATE" @( "CURRENT_TIMESTAMP" | "NOW" | "LOCALTIME" | "LOCALTIMESTAMP" ) ( "(" Number? ")" )? )?`
 	Visible                   bool                       `( @"VISIBLE" | "INVISIBLE" )?`
 	AutoIncrement             bool                       `@"AUTO_INCREMENT"? `
 	UniqueKey                 bool                       `@( "UNIQUE" "KEY"? )?`
@@ -147,10 +148,37 @@ type ColumnDataType struct {
 	String  *StringDataType  `| @@`
 	EnumSet *EnumDataType    `| @@`
 	Blob    *BlobDataType    `| @@`
+	Decimal *DecimalDataType `| @@`
+	Float   *FloatDataType   `| @@`
+	Date    *DateDataType    `| @@`
+	Spatial *UppercaseString `| @( "GEOMETRY" | "POINT" | "LINESTRING" | "POLYGON" | "MULTIPOINT" | "MULTILINESTRING" | "MULTIPOLYGON" | "GEOMETRYCOLLECTION" )`
+	Json    bool             `| @"JSON"`
 	Bool    bool             `| @( "BOOL" | "BOOLEAN" )`
 	Last    bool             `)`
 }
 
+type DecimalDataType struct {
+	Type      *UppercaseString `@( "DECIMAL" | "NUMERIC" | "DEC" | "FIXED" )`
+	Precision *int             `( "(" @Number`
+	Scale     *int             `( "," @Number )? ")" )?`
+	Unsigned  bool             `@"UNSIGNED"?`
+	Zerofill  bool             `@"ZEROFILL"?`
+}
+
+type FloatDataType struct {
+	Type      *UppercaseString `@( "FLOAT" | "DOUBLE" | "REAL" ) "PRECISION"?`
+	Precision *int             `( "(" @Number`
+	Scale     *int             `( "," @Number )? ")" )?`
+	Unsigned  bool             `@"UNSIGNED"?`
+	Zerofill  bool             `@"ZEROFILL"?`
+}
+
+type DateDataType struct {
+	Type *UppercaseString `@( "DATETIME" | "DATE" | "TIMESTAMP" | "TIME" | "YEAR" )`
+	// Precision is the fractional seconds precision, or the display width of YEAR
+	Precision *int `( "(" @Number ")" )?`
+}
+
 type BitDataType struct {
 	Precision *int `"BIT" ( "(" @Number ")" )?`
 }
@@ -194,11 +222,12 @@ func (s *UppercaseString) Capture(values []string) error {
 }
 
 type ColumnDefault struct {
-	Number  *float64 `( @Number`
-	String  *string  ` | @String`
-	Boolean *Boolean ` | @("TRUE" | "FALSE")`
-	Null    bool     ` | @"NULL"`
-	Array   *Array   ` | @@ )`
+	Number           *float64 `( @Number`
+	String           *string  ` | @String`
+	Boolean          *Boolean ` | @("TRUE" | "FALSE")`
+	Null             bool     ` | @"NULL"`
+	CurrentTimestamp bool     ` | @( "CURRENT_TIMESTAMP" | "NOW" | "LOCALTIME" | "LOCALTIMESTAMP" ) ( "(" Number? ")" )?`
+	Array            *Array   ` | @@ )`
 }
 
 // Select based on http://www.h2database.com/html/grammar.html
@@ -223,6 +252,7 @@ var (
 		&CreateTable{},
 		participle.Lexer(sqlLexer),
 		participle.Unquote("String"),
+		unquoteIdentifiers,
 		participle.CaseInsensitive("Keyword"),
 		participle.Elide("Comment"),
 		// Need to solve left recursion detection first, if possible.
@@ -0,0 +1,31 @@
+package grammar
+
+import (
+	"github.com/alecthomas/participle/v2"
+)
+
+// Statement is one of the DDL statements found in the binlog.
+type Statement struct {
+	CreateTable *CreateTable `( @@`
+	AlterTable  *AlterTable  `| @@ )`
+}
+
+var (
+	statementParser = participle.MustBuild(
+		&Statement{},
+		participle.Lexer(sqlLexer),
+		participle.Unquote("String"),
+		unquoteIdentifiers,
+		participle.CaseInsensitive("Keyword"),
+		participle.Elide("Comment"),
+		// alter options share long prefixes (ADD COLUMN ( ... vs ADD COLUMN foo ...)
+		participle.UseLookahead(4),
+	)
+)
+
+// ParseStatement parses a CREATE TABLE or ALTER TABLE statement.
+func ParseStatement(s string) (*Statement, error) {
+	statement := &Statement{}
+	err := statementParser.ParseString("", s, statement)
+	return statement, err
+}
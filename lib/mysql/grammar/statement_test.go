@@ -0,0 +1,94 @@
+package grammar
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseAlterTableAddColumn(t *testing.T) {
+	ast, err := ParseStatement("ALTER TABLE `wp_wc_orders` ADD COLUMN `customer_note` text AFTER `status`")
+	require.Nil(t, err)
+	require.NotNil(t, ast.AlterTable)
+	assert.Equal(t, "wp_wc_orders", ast.AlterTable.Name)
+	require.Len(t, ast.AlterTable.AlterOptions, 1)
+	add := ast.AlterTable.AlterOptions[0].AddSingleColumn
+	require.NotNil(t, add)
+	assert.Equal(t, "customer_note", add.ColumnDefinition.Simple.ColumnName)
+	require.NotNil(t, add.After)
+	assert.Equal(t, "status", *add.After)
+
+	ast, err = ParseStatement("alter table wp_wc_orders add column total_amount decimal(26,8) default null, " +
+		"add (date_paid_gmt datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `type` varchar(20))," +
+		" ALGORITHM=INPLACE, LOCK=NONE")
+	require.Nil(t, err)
+	options := ast.AlterTable.AlterOptions
+	require.Len(t, options, 4)
+	decimal := options[0].AddSingleColumn.ColumnDefinition.Simple.DataType.Decimal
+	require.NotNil(t, decimal)
+	assert.Equal(t, 26, *decimal.Precision)
+	assert.Equal(t, 8, *decimal.Scale)
+	require.Len(t, options[1].AddMultipleColumns, 2)
+	date := options[1].AddMultipleColumns[0].Simple
+	assert.Equal(t, "DATETIME", string(*date.DataType.Date.Type))
+	assert.True(t, date.NotNull)
+	assert.True(t, date.Default.CurrentTimestamp)
+	assert.True(t, date.OnUpdateCurrentTimestamp)
+	assert.Equal(t, "type", options[1].AddMultipleColumns[1].Simple.ColumnName)
+	assert.Equal(t, "INPLACE", *options[2].Algorithm)
+	assert.Equal(t, "NONE", *options[3].Lock)
+}
+
+func TestParseAlterTableOptions(t *testing.T) {
+	for _, tc := range []struct {
+		sql   string
+		check func(o *AlterOption) bool
+	}{
+		{"ALTER TABLE t DROP COLUMN c", func(o *AlterOption) bool { return *o.DropColumn == "c" }},
+		{"ALTER TABLE t DROP c", func(o *AlterOption) bool { return *o.DropColumn == "c" }},
+		{"ALTER TABLE t DROP INDEX idx", func(o *AlterOption) bool { return *o.DropIndex == "idx" }},
+		{"ALTER TABLE t DROP PRIMARY KEY", func(o *AlterOption) bool { return o.DropPrimaryKey }},
+		{"ALTER TABLE t ADD INDEX idx (a, b(10))", func(o *AlterOption) bool { return len(o.AddSimpleIndex.Keys) == 2 }},
+		{"ALTER TABLE t ADD UNIQUE KEY u (a)", func(o *AlterOption) bool { return o.AddUniqueKeyDefinition != nil }},
+		{"ALTER TABLE t MODIFY COLUMN c bigint(20) unsigned NOT NULL", func(o *AlterOption) bool {
+			return o.ModifyColumn.ColumnDefinition.Simple.DataType.Integer.Unsigned
+		}},
+		{"ALTER TABLE t CHANGE old_name new_name double", func(o *AlterOption) bool {
+			return o.ChangeColumn.OldName == "old_name" && o.ChangeColumn.ColumnDefinition.Simple.DataType.Float != nil
+		}},
+		{"ALTER TABLE t RENAME COLUMN a TO b", func(o *AlterOption) bool { return o.RenameColumn.NewName == "b" }},
+		{"ALTER TABLE t RENAME TO u", func(o *AlterOption) bool { return *o.RenameTable == "u" }},
+		{"ALTER TABLE t ALTER COLUMN c SET DEFAULT 'x'", func(o *AlterOption) bool { return *o.AlterColumn.SetDefault.String == "x" }},
+		{"ALTER TABLE t CONVERT TO CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_520_ci", func(o *AlterOption) bool {
+			return o.ConvertCharacterSet.CharacterSet == "utf8mb4"
+		}},
+		{"ALTER TABLE t ENGINE=InnoDB", func(o *AlterOption) bool { return *o.TableOption.Engine == "InnoDB" }},
+		{"ALTER TABLE t ADD COLUMN location point", func(o *AlterOption) bool {
+			return *o.AddSingleColumn.ColumnDefinition.Simple.DataType.Spatial == "POINT"
+		}},
+		{"ALTER TABLE t ADD COLUMN `data` json", func(o *AlterOption) bool {
+			return o.AddSingleColumn.ColumnDefinition.Simple.DataType.Json
+		}},
+	} {
+		ast, err := ParseStatement(tc.sql)
+		if assert.Nil(t, err, tc.sql) && assert.NotNil(t, ast.AlterTable, tc.sql) {
+			require.Len(t, ast.AlterTable.AlterOptions, 1, tc.sql)
+			assert.True(t, tc.check(ast.AlterTable.AlterOptions[0]), tc.sql)
+		}
+	}
+}
+
+func TestParseCreateTableStatement(t *testing.T) {
+	ast, err := ParseStatement("CREATE TABLE IF NOT EXISTS `wp_wc_order_notes` (\n" +
+		"`id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"`created_at` timestamp(6) NULL DEFAULT NULL,\n" +
+		"`note` longtext COLLATE utf8mb4_unicode_520_ci,\n" +
+		"PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
+	require.Nil(t, err)
+	require.NotNil(t, ast.CreateTable)
+	assert.Equal(t, "wp_wc_order_notes", ast.CreateTable.Name)
+	assert.True(t, ast.CreateTable.IfNotExists)
+	require.Len(t, ast.CreateTable.CreateDefinition, 4)
+	assert.Equal(t, 6, *ast.CreateTable.CreateDefinition[1].ColumnDefinition.Simple.DataType.Date.Precision)
+}
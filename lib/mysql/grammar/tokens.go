@@ -2,6 +2,7 @@ package grammar
 
 import (
 	"fmt"
+	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 	"strings"
 )
@@ -28,8 +29,10 @@ var keywords = []string{
 	"NATIONAL",
 
 	"INSTANT", "INPLACE", "COPY", "ALGORITHM", "CHANGE", "AFTER", "FIRST", "DROP", "CONVERT", "DISABLE", "ENABLE",
-	"DISCARD". "IMPORT", "LOCK", "RENAME", "MODIFY", "SHARED", "EXCLUSIVE" ,"WITHOUT", "VALIDATION", "TO",
+	"DISCARD", "IMPORT", "LOCK", "RENAME", "MODIFY", "SHARED", "EXCLUSIVE" ,"WITHOUT", "VALIDATION", "TO",
 	"TRUNCATE", "DISCARD", "COALESCE", "REORGANIZE", "ANALYZE", "OPTIMIZE", "REBUILD", "REPAIR", "REMOVE",
+
+	"ADD", "ALTER", "CHARSET", "COLUMN", "NONE",
 }
 
 var types = []string{
@@ -43,10 +46,15 @@ var types = []string{
 	"UNSIGNED", "ZEROFILL",
 	"NUMERIC", "DECIMAL", "DEC", "FIXED",
 	"GEOMETRY", "POINT", "LINESTRING", "POLYGON", "MULTIPOINT", "MULTILINESTRING", "MULTIPOLYGON", "GEOMETRYCOLLECTION",
+	"JSON",
 }
 
 var sqlLexer = lexer.MustSimple([]lexer.Rule{
 	{Name: "Comment", Pattern: ` //.*|/\*.*?\*/`},
+	{
+		// quoted identifiers can be keywords, so they are matched first
+		Name: `QuotedIdent`, Pattern: "`(?:[^`]|``)+`",
+	},
 	{
 		Name:    `Keyword`,
 		Pattern: fmt.Sprintf(`(?i)\b(%s)\b`, strings.Join(append(keywords, types...), "|")),
@@ -68,3 +76,10 @@ var sqlLexer = lexer.MustSimple([]lexer.Rule{
 	},
 },
 )
+
+// unquoteIdentifiers turns `quoted` identifiers into plain identifiers
+var unquoteIdentifiers = participle.Map(func(t lexer.Token) (lexer.Token, error) {
+	t.Value = strings.ReplaceAll(t.Value[1:len(t.Value)-1], "``", "`")
+	t.Type = sqlLexer.Symbols()["Ident"]
+	return t, nil
+}, "QuotedIdent")
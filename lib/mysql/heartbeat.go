@@ -0,0 +1,38 @@
+package mysql
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+const DefaultHeartbeatTable = "majipoor_heartbeat"
+
+// HeartbeatTimeColumn holds the time of the heartbeat, in microseconds since the epoch,
+// so that it decodes the same from the binlog whatever the time zone of the server.
+const HeartbeatTimeColumn = "written_at"
+
+// CreateHeartbeatTable creates the table heartbeats are written to. It has a row per
+// binlog stream, identified by its server ID.
+func (md *MysqlDB) CreateHeartbeatTable(database string, table string) error {
+	_, err := md.Db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+	server_id INT UNSIGNED NOT NULL PRIMARY KEY,
+	%s BIGINT NOT NULL
+)`, QuoteIdentifier(database), QuoteIdentifier(table), QuoteIdentifier(HeartbeatTimeColumn)))
+	if err != nil {
+		return errors.Wrapf(err, "Could not create heartbeat table %s.%s", database, table)
+	}
+	return nil
+}
+
+// WriteHeartbeat writes the current time of the server to the row of serverID.
+func (md *MysqlDB) WriteHeartbeat(database string, table string, serverID uint32) error {
+	_, err := md.Db.Exec(fmt.Sprintf(`INSERT INTO %s.%s (server_id, %s)
+VALUES (?, CAST(UNIX_TIMESTAMP(NOW(6)) * 1000000 AS SIGNED))
+ON DUPLICATE KEY UPDATE %s = VALUES(%s)`,
+		QuoteIdentifier(database), QuoteIdentifier(table), QuoteIdentifier(HeartbeatTimeColumn),
+		QuoteIdentifier(HeartbeatTimeColumn), QuoteIdentifier(HeartbeatTimeColumn)), serverID)
+	if err != nil {
+		return errors.Wrap(err, "Could not write heartbeat")
+	}
+	return nil
+}
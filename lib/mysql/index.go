@@ -0,0 +1,98 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/huandu/go-sqlbuilder"
+	"strings"
+)
+
+// IndexMetadata is an index of a table, as described by information_schema.STATISTICS.
+// The slices have one entry per part of the index, in order.
+type IndexMetadata struct {
+	Name    string
+	Unique  bool
+	Columns []string
+	// Nullable is true if any of the columns of the index can be NULL
+	Nullable bool
+	// Type is BTREE, HASH, FULLTEXT or SPATIAL
+	Type string
+	// Prefixes are the indexed lengths of the columns (in characters, or bytes for binary
+	// columns), 0 if the whole column is indexed
+	Prefixes   []int
+	Descending []bool
+	// Functional is true if some parts are expressions (mysql 8.0.13 and later), their column is ""
+	Functional bool
+}
+
+func (i *IndexMetadata) IsPrimary() bool {
+	return i.Name == "PRIMARY"
+}
+
+func (i *IndexMetadata) IsFulltext() bool {
+	return i.Type == "FULLTEXT"
+}
+
+func (i *IndexMetadata) IsSpatial() bool {
+	return i.Type == "SPATIAL"
+}
+
+// IsPrefix returns true if some columns are only indexed up to a length.
+func (i *IndexMetadata) IsPrefix() bool {
+	for _, p := range i.Prefixes {
+		if p > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetIndexes returns the indexes of table, in the order of information_schema.STATISTICS.
+func (md *MysqlDB) GetIndexes(schema string, table string) ([]*IndexMetadata, error) {
+	sb := sqlbuilder.Select("INDEX_NAME", "NON_UNIQUE", "COLUMN_NAME", "NULLABLE", "INDEX_TYPE",
+		"SUB_PART", "COLLATION").
+		From("information_schema.STATISTICS")
+	sb.Where(sb.Equal("TABLE_SCHEMA", schema))
+	sb.Where(sb.Equal("TABLE_NAME", table))
+	sb.OrderBy("INDEX_NAME", "SEQ_IN_INDEX")
+	sql_, args := sb.Build()
+
+	rows, err := md.Db.Query(sql_, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var indexes []*IndexMetadata
+	var current *IndexMetadata
+	for rows.Next() {
+		var name, nullable, indexType string
+		var columnName, collation sql.NullString
+		var subPart sql.NullInt64
+		var nonUnique int
+		err = rows.Scan(&name, &nonUnique, &columnName, &nullable, &indexType, &subPart, &collation)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.Name != name {
+			current = &IndexMetadata{Name: name, Unique: nonUnique == 0, Type: strings.ToUpper(indexType)}
+			indexes = append(indexes, current)
+		}
+		// the parts of functional indexes have no column
+		if !columnName.Valid {
+			current.Functional = true
+		}
+		current.Columns = append(current.Columns, columnName.String)
+		current.Prefixes = append(current.Prefixes, int(subPart.Int64))
+		current.Descending = append(current.Descending, collation.String == "D")
+		if nullable == "YES" {
+			current.Nullable = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return indexes, nil
+}
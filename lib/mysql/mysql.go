@@ -5,22 +5,55 @@ import (
 	"fmt"
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
 )
 
 type MysqlSlaveStatus struct {
-	RetrievedGtidSet string `Db:"Retrieved_Gtid_Set"`
+	RetrievedGtidSet string `db:"Retrieved_Gtid_Set"`
 }
 
+// MysqlGlobalVariables are the server variables that matter for replication. Each field is
+// tagged with the name of the variable for each flavor that has it.
 type MysqlGlobalVariables struct {
+	Flavor         string
 	GtidMode       string `mysql:"gtid_mode"`
-	LogBin         string `mysql:"log_bin"`
-	BinlogFormat   string `mysql:"binlog_format"`
-	BinlogRowImage string `mysql:"binlog_row_image"`
+	LogBin         string `mysql:"log_bin" mariadb:"log_bin"`
+	BinlogFormat   string `mysql:"binlog_format" mariadb:"binlog_format"`
+	BinlogRowImage string `mysql:"binlog_row_image" mariadb:"binlog_row_image"`
 	ServerUuid     string `mysql:"server_uuid"`
+	// mariadb always has GTIDs, there is no gtid_mode
+	GtidBinlogPos  string `mariadb:"gtid_binlog_pos"`
+	GtidStrictMode string `mariadb:"gtid_strict_mode"`
+}
+
+// ReplicationMode is how the binlog stream is positioned.
+type ReplicationMode string
+
+const (
+	// ReplicationGTID follows GTID sets, which survive binlog rotation and failovers.
+	ReplicationGTID ReplicationMode = "gtid"
+	// ReplicationFilePosition follows binlog file names and positions, which are only valid
+	// on the server they were read from. This is a degraded mode for servers without GTIDs.
+	ReplicationFilePosition ReplicationMode = "file-position"
+	// ReplicationImpossible means the binlog can't be used for replication.
+	ReplicationImpossible ReplicationMode = ""
+)
+
+// ReplicationMode returns the best replication mode the server configuration allows.
+// Row based binary logging with full row images is always required.
+func (v *MysqlGlobalVariables) ReplicationMode() ReplicationMode {
+	if v.LogBin != "ON" || v.BinlogFormat != "ROW" || v.BinlogRowImage != "FULL" {
+		return ReplicationImpossible
+	}
+	if v.GtidMode == "ON" || v.Flavor == MariaDBFlavor {
+		return ReplicationGTID
+	}
+	return ReplicationFilePosition
 }
 
 type MysqlDB struct {
@@ -53,7 +86,11 @@ func getMysqlVariable(db *sqlx.DB, variableName string) (string, error) {
 }
 
 func (md *MysqlDB) GetMysqlGlobalVariables() (*MysqlGlobalVariables, error) {
-	var config MysqlGlobalVariables
+	version, err := md.GetServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	config := MysqlGlobalVariables{Flavor: version.Flavor}
 	t := reflect.TypeOf(config)
 	ps := reflect.ValueOf(&config)
 	s := ps.Elem()
@@ -61,7 +98,7 @@ func (md *MysqlDB) GetMysqlGlobalVariables() (*MysqlGlobalVariables, error) {
 		f := t.Field(i)
 		sf := s.Field(i)
 
-		v, ok := f.Tag.Lookup("mysql")
+		v, ok := f.Tag.Lookup(config.Flavor)
 		if ok {
 			_log := log.With().Str("field", f.Name).Str("mysql-variable", v).Logger()
 			value, err := getMysqlVariable(md.Db, v)
@@ -78,7 +115,7 @@ func (md *MysqlDB) GetMysqlGlobalVariables() (*MysqlGlobalVariables, error) {
 			}
 			_log.Info().Str("value", value).Send()
 		} else {
-			log.Warn().Str("field", f.Name).Msg("No mysql tag found")
+			log.Debug().Str("field", f.Name).Str("flavor", config.Flavor).Msg("No variable for this flavor")
 		}
 	}
 
@@ -141,21 +178,90 @@ func (md *MysqlDB) GetTables(schema string, limitTables []string, skipTables []s
 }
 
 type ColumnMetadata struct {
-	ColumnName             string  `Db:"column_name"`
-	ColumnDefault          *string `Db:"column_default"`
-	OrdinalPosition        int     `Db:"ordinal_position"`
-	DataType               string  `Db:"data_type"`
-	ColumnType             string  `Db:"column_type"`
-	CharacterMaximumLength *int    `Db:"character_maximum_length"`
-	Extra                  string  `Db:"extra"`
-	ColumnKey              string  `Db:"column_key"`
-	IsNullable             string  `Db:"is_nullable"`
-	NumericPrecision       *int    `Db:"numeric_precision"`
-	NumericScale           *int    `Db:"numeric_scale"`
-	EnumList               *string `Db:"enum_list"`
+	ColumnName             string  `db:"column_name"`
+	ColumnDefault          *string `db:"column_default"`
+	OrdinalPosition        int     `db:"ordinal_position"`
+	DataType               string  `db:"data_type"`
+	ColumnType             string  `db:"column_type"`
+	CharacterMaximumLength *int    `db:"character_maximum_length"`
+	Extra                  string  `db:"extra"`
+	ColumnKey              string  `db:"column_key"`
+	IsNullable             string  `db:"is_nullable"`
+	NumericPrecision       *int    `db:"numeric_precision"`
+	NumericScale           *int    `db:"numeric_scale"`
+	EnumList               *string `db:"enum_list"`
+}
+
+// TableMetadata are the table level properties of a table, from information_schema.TABLES.
+// The statistics (rows, data length) are estimates, and can be cached by the server.
+type TableMetadata struct {
+	TableName     string  `db:"table_name"`
+	Engine        *string `db:"engine"`
+	CharacterSet  *string `db:"character_set"`
+	Collation     *string `db:"table_collation"`
+	EstimatedRows *uint64 `db:"table_rows"`
+	DataLength    *uint64 `db:"data_length"`
+	// AutoIncrement is the next AUTO_INCREMENT value, nil if the table has no such column
+	AutoIncrement *uint64 `db:"auto_increment"`
+	CreateOptions *string `db:"create_options"`
+	Comment       string  `db:"table_comment"`
+}
+
+// transactionalEngines are the engines whose tables can be read in a consistent snapshot
+var transactionalEngines = []string{"innodb", "rocksdb", "tokudb"}
+
+// IsTransactional returns true if the engine of the table takes part in transactions, and
+// so in a consistent snapshot. The rows of other tables (MyISAM, MEMORY, Aria, ...) are read
+// as they are at the time of the read, which may not match the binlog position.
+func (t *TableMetadata) IsTransactional() bool {
+	return t.Engine != nil && contains(strings.ToLower(*t.Engine), transactionalEngines)
+}
+
+// GetTableMetadata returns the table level metadata of table.
+func (md *MysqlDB) GetTableMetadata(schema string, table string) (*TableMetadata, error) {
+	// the collations of mariadb 10.10+ can apply to several character sets, and are then
+	// missing from COLLATIONS under their full name (utf8mb4_uca1400_ai_ci), which starts
+	// with the character set
+	sb := sqlbuilder.Select("t.TABLE_NAME AS table_name", "t.ENGINE AS engine",
+		"COALESCE(c.CHARACTER_SET_NAME, SUBSTRING_INDEX(t.TABLE_COLLATION, '_', 1)) AS character_set",
+		"t.TABLE_COLLATION AS table_collation", "t.TABLE_ROWS AS table_rows", "t.DATA_LENGTH AS data_length",
+		"t.AUTO_INCREMENT AS auto_increment", "t.CREATE_OPTIONS AS create_options",
+		"t.TABLE_COMMENT AS table_comment").
+		From("information_schema.TABLES t").
+		JoinWithOption(sqlbuilder.LeftJoin, "information_schema.COLLATIONS c", "c.COLLATION_NAME = t.TABLE_COLLATION")
+	sb.Where(sb.Equal("t.TABLE_SCHEMA", schema))
+	sb.Where(sb.Equal("t.TABLE_NAME", table))
+	sql_, args := sb.Build()
+
+	var metadata TableMetadata
+	err := md.Db.QueryRowx(sql_, args...).StructScan(&metadata)
+	if err == sql.ErrNoRows {
+		return nil, errors.Errorf("Unknown table %s.%s", schema, table)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get table metadata of %s", table)
+	}
+	return &metadata, nil
+}
+
+var enumValuesRegexp = regexp.MustCompile(`'((?:[^']|'')*)'`)
+
+// GetEnumValues returns the values of an enum or set column, parsed from its column type
+// (for example "enum('a','b')")
+func (c *ColumnMetadata) GetEnumValues() []string {
+	var values []string
+	for _, match := range enumValuesRegexp.FindAllStringSubmatch(c.ColumnType, -1) {
+		values = append(values, strings.ReplaceAll(match[1], "''", "'"))
+	}
+	return values
+}
+
+func (c *ColumnMetadata) IsUnsigned() bool {
+	return strings.Contains(strings.ToLower(c.ColumnType), "unsigned")
 }
 
-func (md *MysqlDB) GetTableMetadata(schema string, table string) ([]*ColumnMetadata, error) {
+// GetColumnMetadata returns the columns of table, in order.
+func (md *MysqlDB) GetColumnMetadata(schema string, table string) ([]*ColumnMetadata, error) {
 	var metadatas []*ColumnMetadata
 	sb := sqlbuilder.Select("column_name", "column_default", "ordinal_position",
 		"data_type", "column_type", "character_maximum_length", "extra", "column_key",
@@ -180,19 +286,20 @@ func (md *MysqlDB) GetTableMetadata(schema string, table string) ([]*ColumnMetad
 		_ = rows.Close()
 	}()
 	for rows.Next() {
-		var tableMetadata ColumnMetadata
-		err = rows.StructScan(&tableMetadata)
+		var columnMetadata ColumnMetadata
+		err = rows.StructScan(&columnMetadata)
 		if err != nil {
 			return nil, err
 		}
-		metadatas = append(metadatas, &tableMetadata)
+		metadatas = append(metadatas, &columnMetadata)
 	}
 
 	return metadatas, nil
 }
 
 var spatialDatatypes = []string{
-	"point", "geometry", "linestring", "polygon", "multipoint", "multilinestring", "geometrycollection",
+	"point", "geometry", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon",
+	"geometrycollection",
 }
 
 var hexTypes = []string{
@@ -210,46 +317,58 @@ func contains(needle string, haystack []string) bool {
 	return false
 }
 
-var defaultCharacterSet = "utf8"
+var defaultCharacterSet = "utf8mb4"
+
+// QuoteIdentifier quotes a mysql identifier with backticks
+func QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
 
 // TODO(manuel) This can probably be done in Go, not in SQL
 func (c *ColumnMetadata) getSelectCSVStatement() string {
+	name := QuoteIdentifier(c.ColumnName)
 	if contains(c.DataType, hexTypes) {
-		return fmt.Sprintf("hex(%s)", c.ColumnName)
+		return fmt.Sprintf("hex(%s)", name)
 	}
 	if c.DataType == "bit" {
-		return fmt.Sprintf("cast(%s AS unsigned)", c.ColumnName)
+		return fmt.Sprintf("cast(%s AS unsigned)", name)
 	}
 	if contains(c.DataType, []string{"datetime", "timestamp", "date"}) {
-		return fmt.Sprintf("nullif(%s, cast(\"0000-00-00 00:00:00\" AS date))", c.ColumnName)
+		// zero dates don't compare equal to any date, their string form is checked
+		return fmt.Sprintf("IF(cast(%s AS char) LIKE '0000-00-00%%', NULL, %s)", name, name)
 	}
 	if contains(c.DataType, spatialDatatypes) {
-		return fmt.Sprintf("ST_AsText(%s)", c.ColumnName)
+		return fmt.Sprintf("ST_AsText(%s)", name)
 	}
 
-	return fmt.Sprintf("cast(%s AS char CHARACTER SET %s)", c.ColumnName, defaultCharacterSet)
-}
-
-func mapValues(m map[string]string) []string {
-	res := []string{}
-	for _, v := range m {
-		res = append(res, v)
-	}
-	return res
+	return fmt.Sprintf("cast(%s AS char CHARACTER SET %s)", name, defaultCharacterSet)
 }
 
 func GetSelectCSVSatement(table string, columns []*ColumnMetadata) string {
 	_ = table
-	selects := map[string]string{}
-	selectCsvs := map[string]string{}
+	var selectCsvs []string
 	for _, c := range columns {
 		statement := c.getSelectCSVStatement()
-		selects[c.ColumnName] = statement
-		selectCsvs[c.ColumnName] = fmt.Sprintf("COALESCE(REPLACE(%s, '\"', '\"\"'), 'NULL')", statement)
-		log.Debug().Str("column", c.ColumnName).Str("type", c.ColumnType).Str("select", selects[c.ColumnName]).Send()
+		selectCsvs = append(selectCsvs, fmt.Sprintf("COALESCE(REPLACE(%s, '\"', '\"\"'), 'NULL')", statement))
+		log.Debug().Str("column", c.ColumnName).Str("type", c.ColumnType).Str("select", statement).Send()
 	}
 	return fmt.Sprintf("REPLACE(CONCAT('\"',CONCAT_WS('\",\"',%s),'\"'),'\"NULL\"','NULL')",
-		strings.Join(mapValues(selectCsvs), ",\n"))
+		strings.Join(selectCsvs, ",\n"))
+}
+
+// GetSelectStatement returns a SELECT returning every column of table as text, in column order,
+// converted the same way as GetSelectCSVSatement (hex for binary, NULL for zero dates), except
+// for spatial values, which are the hex of their storage format, as in the binlog.
+func GetSelectStatement(table string, columns []*ColumnMetadata) string {
+	var selects []string
+	for _, c := range columns {
+		if contains(c.DataType, spatialDatatypes) {
+			selects = append(selects, fmt.Sprintf("hex(%s)", QuoteIdentifier(c.ColumnName)))
+			continue
+		}
+		selects = append(selects, c.getSelectCSVStatement())
+	}
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), QuoteIdentifier(table))
 }
 
 func (md *MysqlDB) Exec(sql string, args ...any) (sql.Result, error) {
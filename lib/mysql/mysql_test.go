@@ -0,0 +1,30 @@
+package mysql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetSelectStatement(t *testing.T) {
+	columns := []*ColumnMetadata{
+		{ColumnName: "id", DataType: "int"},
+		{ColumnName: "created", DataType: "datetime"},
+		{ColumnName: "data", DataType: "blob"},
+		{ColumnName: "location", DataType: "point"},
+	}
+	assert.Equal(t, "SELECT cast(`id` AS char CHARACTER SET utf8mb4), "+
+		"IF(cast(`created` AS char) LIKE '0000-00-00%', NULL, `created`), hex(`data`), hex(`location`) FROM `wp_posts`",
+		GetSelectStatement("wp_posts", columns))
+}
+
+func TestIsTransactional(t *testing.T) {
+	for engine, expected := range map[string]bool{
+		"InnoDB": true, "ROCKSDB": true, "TokuDB": true,
+		"MyISAM": false, "MEMORY": false, "Aria": false, "CSV": false,
+	} {
+		engine := engine
+		assert.Equal(t, expected, (&TableMetadata{Engine: &engine}).IsTransactional(), engine)
+	}
+	// views have no engine
+	assert.False(t, (&TableMetadata{}).IsTransactional())
+}
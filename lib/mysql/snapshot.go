@@ -0,0 +1,199 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"strconv"
+)
+
+type MasterStatus struct {
+	File            string
+	Position        uint32
+	ExecutedGtidSet string
+}
+
+// ConsistentSnapshot is a connection holding a transaction started WITH CONSISTENT SNAPSHOT,
+// along with the binlog coordinates at the time the snapshot was taken.
+//
+// Every query run through it sees the data as of MasterStatus, so that binlog
+// streaming can resume exactly from there.
+type ConsistentSnapshot struct {
+	MasterStatus MasterStatus
+	conn         *sql.Conn
+}
+
+func scanMasterStatus(rows *sql.Rows) (*MasterStatus, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("SHOW MASTER STATUS returned no rows, is binary logging enabled?")
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = rows.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MasterStatus{}
+	for i, column := range columns {
+		switch column {
+		case "File":
+			status.File = values[i].String
+		case "Position":
+			position, err := strconv.ParseUint(values[i].String, 10, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not parse binlog position %s", values[i].String)
+			}
+			status.Position = uint32(position)
+		case "Executed_Gtid_Set":
+			status.ExecutedGtidSet = values[i].String
+		}
+	}
+
+	return status, nil
+}
+
+// StartConsistentSnapshot briefly locks all tables to start a consistent snapshot transaction
+// and read the matching binlog coordinates. The lock is released before returning.
+//
+// This requires the RELOAD privilege.
+func (md *MysqlDB) StartConsistentSnapshot(ctx context.Context) (*ConsistentSnapshot, error) {
+	snapshots, err := md.StartConsistentSnapshots(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots[0], nil
+}
+
+// StartConsistentSnapshots starts n consistent snapshot transactions on n connections of the pool,
+// all under the same global read lock, so that they all see the same data and share the
+// same binlog coordinates. This allows reading tables in parallel.
+//
+// The first connection takes the lock, which is released before returning.
+func (md *MysqlDB) StartConsistentSnapshots(ctx context.Context, n int) ([]*ConsistentSnapshot, error) {
+	if n < 1 {
+		return nil, errors.Errorf("Invalid number of snapshots %d", n)
+	}
+	if maxOpen := md.Db.Stats().MaxOpenConnections; maxOpen > 0 && n > maxOpen {
+		return nil, errors.Errorf("Cannot start %d snapshots with a pool of %d connections", n, maxOpen)
+	}
+
+	var snapshots []*ConsistentSnapshot
+	closeAll := func() {
+		for _, cs := range snapshots {
+			_ = cs.conn.Close()
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		conn, err := md.Db.Conn(ctx)
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrap(err, "Could not get connection")
+		}
+		snapshots = append(snapshots, &ConsistentSnapshot{conn: conn})
+	}
+
+	lock := snapshots[0]
+	err := lock.start(ctx, snapshots)
+	if err != nil {
+		_, _ = lock.conn.ExecContext(ctx, "UNLOCK TABLES")
+		closeAll()
+		return nil, err
+	}
+
+	log.Info().Str("gtid-set", lock.MasterStatus.ExecutedGtidSet).
+		Str("binlog-file", lock.MasterStatus.File).
+		Uint32("binlog-position", lock.MasterStatus.Position).
+		Int("connections", n).
+		Msg("Started consistent snapshot")
+
+	return snapshots, nil
+}
+
+// start locks the tables on cs, starts the snapshot transaction of each of snapshots
+// (which includes cs), reads the binlog coordinates and unlocks the tables.
+func (cs *ConsistentSnapshot) start(ctx context.Context, snapshots []*ConsistentSnapshot) error {
+	log.Debug().Str("sql", "FLUSH TABLES WITH READ LOCK").Msg("Locking tables")
+	_, err := cs.conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK")
+	if err != nil {
+		return errors.Wrap(err, "Could not lock tables")
+	}
+
+	for _, snapshot := range snapshots {
+		for _, s := range []step{
+			{"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ", "Setting isolation level", "Could not set isolation level"},
+			// timestamps are read in UTC, like the binlog streamer decodes them
+			{"SET SESSION time_zone = '+00:00'", "Setting time zone", "Could not set time zone"},
+			{"START TRANSACTION WITH CONSISTENT SNAPSHOT", "Starting consistent snapshot", "Could not start consistent snapshot"},
+		} {
+			log.Debug().Str("sql", s.statement).Msg(s.runningDescription)
+			_, err := snapshot.conn.ExecContext(ctx, s.statement)
+			if err != nil {
+				return errors.Wrap(err, s.errorDescription)
+			}
+		}
+	}
+
+	rows, err := cs.conn.QueryContext(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		return errors.Wrap(err, "Could not get master status")
+	}
+	status, err := scanMasterStatus(rows)
+	_ = rows.Close()
+	if err != nil {
+		return errors.Wrap(err, "Could not get master status")
+	}
+	var version string
+	err = cs.conn.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version)
+	if err != nil {
+		return errors.Wrap(err, "Could not get server version")
+	}
+	serverVersion, err := ParseServerVersion(version)
+	if err != nil {
+		return err
+	}
+	if serverVersion.IsMariaDB() {
+		// mariadb has no Executed_Gtid_Set, the GTID position of its binlog is a variable
+		err = cs.conn.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_binlog_pos").Scan(&status.ExecutedGtidSet)
+		if err != nil {
+			return errors.Wrap(err, "Could not get binlog GTID position")
+		}
+	}
+	for _, snapshot := range snapshots {
+		snapshot.MasterStatus = *status
+	}
+
+	_, err = cs.conn.ExecContext(ctx, "UNLOCK TABLES")
+	if err != nil {
+		return errors.Wrap(err, "Could not unlock tables")
+	}
+
+	return nil
+}
+
+func (cs *ConsistentSnapshot) Query(query string, args ...any) (*sql.Rows, error) {
+	return cs.conn.QueryContext(context.Background(), query, args...)
+}
+
+// Close ends the snapshot transaction and returns the connection to the pool.
+func (cs *ConsistentSnapshot) Close() error {
+	_, err := cs.conn.ExecContext(context.Background(), "COMMIT")
+	if err != nil {
+		_ = cs.conn.Close()
+		return errors.Wrap(err, "Could not end snapshot transaction")
+	}
+	return cs.conn.Close()
+}
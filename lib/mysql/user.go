@@ -132,12 +132,23 @@ func (md *MysqlDB) CreateReplicaUser(settings CreateReplicaUserSettings) error {
 		}
 	}
 
+	version, err := md.GetServerVersion()
+	if err != nil {
+		return err
+	}
+
+	// SHOW MASTER STATUS needs REPLICATION CLIENT, which mariadb 10.5 split into BINLOG MONITOR
+	monitorPrivilege := "REPLICATION CLIENT"
+	if version.IsMariaDB() && version.AtLeast(10, 5) {
+		monitorPrivilege = "BINLOG MONITOR"
+	}
+
+	// PASSWORD() doesn't exist anymore in mysql 8, IDENTIFIED BY works everywhere
 	statements := []step{
-		{"CREATE USER ${User}", "Creating user", "Could not create user"},
-		{"SET PASSWORD FOR ${User} = PASSWORD('${Password}')", "Setting password", "Could not set password"},
+		{"CREATE USER ${User} IDENTIFIED BY '${Password}'", "Creating user", "Could not create user"},
 		{"GRANT ALL ON ${Schema}.* TO ${User}", "Granting privileges", "Could not grant privileges"},
 		{"GRANT RELOAD ON *.* TO ${User}", "Granting reload privileges", "Could not grant reload privileges"},
-		{"GRANT REPLICATION CLIENT ON *.* TO ${User}", "Granting replication privileges", "Could not grant replication privileges"},
+		{"GRANT " + monitorPrivilege + " ON *.* TO ${User}", "Granting replication privileges", "Could not grant replication privileges"},
 		{"GRANT REPLICATION SLAVE ON *.* TO ${User}", "Granting replication slave privileges", "Could not grant replication slave privileges"},
 		{"FLUSH PRIVILEGES", "Flushing privileges", "Could not flush privileges"},
 	}
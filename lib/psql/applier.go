@@ -0,0 +1,749 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"majipoor/lib/binlog"
+	"majipoor/lib/mysql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postgresql doesn't allow more than 65535 parameters per statement
+const maxStatementParameters = 65535
+
+type ApplierSettings struct {
+	Schema  string
+	Mapping MappingSettings
+	// BatchSize is the number of row changes applied per transaction when coalescing.
+	// A mysql transaction is never split, so a larger one makes a larger batch.
+	BatchSize int
+	// Coalesce groups several mysql transactions into one postgresql transaction,
+	// otherwise each mysql transaction is applied in its own
+	Coalesce bool
+	// FlushInterval is the maximum time a row change waits before being applied
+	FlushInterval time.Duration
+	// Upsert turns inserts into INSERT ... ON CONFLICT DO UPDATE, and updates of missing rows into inserts,
+	// for tables with a primary key
+	Upsert bool
+	// UpsertUntil forces upserts until the stream has passed it, see Checkpoint.UpsertUntil
+	UpsertUntil *binlog.Position
+	// TablePositions are the positions of the tables that are ahead of the checkpoint, by
+	// table. Their changes are skipped until the stream has passed them, see TablePosition.
+	TablePositions map[string]binlog.Position
+	// MetadataSchema is where the checkpoint is saved with every batch
+	MetadataSchema string
+	// CheckpointName is the name of the checkpoint and of the replication status, DefaultCheckpointName
+	// if empty. The binlog stream resumes from the default checkpoint.
+	CheckpointName string
+}
+
+// Applier applies binlog row changes to the mapped postgresql tables, in batches.
+//
+// Each batch is applied in a single transaction, along with the checkpoint of the position
+// it ends at. Batches only contain whole committed mysql transactions, so that the changes
+// of a transaction become visible together, and resuming from the checkpoint after a crash
+// neither loses nor duplicates changes.
+type Applier struct {
+	pd        *PsqlDB
+	settings  ApplierSettings
+	pending   []*binlog.RowChange
+	lastFlush time.Time
+	tables    map[string]*Table
+
+	// committed is the number of pending changes that belong to committed transactions
+	committed int
+	// transactions is the number of committed transactions with pending changes
+	transactions int
+	// position is the position after the last committed transaction
+	position *binlog.Position
+	// checkpointed is true if position has been saved
+	checkpointed bool
+	// upsertUntil is the position up to which changes are upserts, nil once it has been passed
+	upsertUntil *binlog.Position
+	// tablePositions are the table positions the stream hasn't passed yet
+	tablePositions map[string]binlog.Position
+
+	// eventTime is when the last committed transaction was written to the binlog
+	eventTime *time.Time
+	// heartbeat is the last heartbeat of a committed transaction, pendingHeartbeat the one
+	// of the transaction in progress
+	heartbeat        *time.Time
+	pendingHeartbeat *time.Time
+	lag              time.Duration
+}
+
+func NewApplier(pd *PsqlDB, settings ApplierSettings) *Applier {
+	if settings.CheckpointName == "" {
+		settings.CheckpointName = DefaultCheckpointName
+	}
+	a := &Applier{
+		pd:             pd,
+		settings:       settings,
+		lastFlush:      time.Now(),
+		tables:         map[string]*Table{},
+		upsertUntil:    settings.UpsertUntil,
+		tablePositions: map[string]binlog.Position{},
+	}
+	for table, position := range settings.TablePositions {
+		a.tablePositions[table] = position
+	}
+	return a
+}
+
+// Add queues changes. They are applied once their transaction is committed.
+func (a *Applier) Add(changes ...*binlog.RowChange) {
+	a.pending = append(a.pending, changes...)
+}
+
+// Commit marks the pending changes as the transaction committed at position, written to
+// the binlog at eventTime.
+//
+// Without coalescing, the transaction is applied right away. Otherwise it is applied
+// once the batch is full or the flush interval has elapsed, and the previous transactions
+// are applied first if adding it would overflow the batch.
+func (a *Applier) Commit(position binlog.Position, eventTime time.Time) error {
+	if err := a.skipLoaded(position); err != nil {
+		return err
+	}
+	size := len(a.pending) - a.committed
+	decision := decideBatch(a.settings.Coalesce, a.settings.BatchSize, a.committed, size)
+	if decision.flushFirst {
+		if err := a.Flush(); err != nil {
+			return err
+		}
+	}
+
+	a.committed = len(a.pending)
+	a.position = &position
+	a.eventTime = &eventTime
+	if a.pendingHeartbeat != nil {
+		a.heartbeat, a.pendingHeartbeat = a.pendingHeartbeat, nil
+	}
+	a.checkpointed = false
+	if size > 0 {
+		a.transactions++
+	}
+
+	if decision.flush {
+		return a.Flush()
+	}
+	return a.FlushIfDue()
+}
+
+// skipLoaded drops the changes of the transaction committed at position to the tables that
+// already hold them.
+func (a *Applier) skipLoaded(position binlog.Position) error {
+	if len(a.tablePositions) == 0 {
+		return nil
+	}
+	changes := a.pending[:a.committed]
+	for _, change := range a.pending[a.committed:] {
+		if tablePosition, ok := a.tablePositions[change.Table]; ok {
+			loaded, err := tablePosition.Includes(position)
+			if err != nil {
+				return err
+			}
+			if loaded {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+	a.pending = changes
+	return nil
+}
+
+// batchDecision is what Commit does with a transaction. The batch is applied once the flush
+// interval has elapsed unless it is flushed right away.
+type batchDecision struct {
+	// flushFirst applies the previous transactions before adding the transaction to the batch
+	flushFirst bool
+	// flush applies the batch with the transaction right away
+	flush bool
+}
+
+// decideBatch returns the batchDecision of a transaction of size changes, committed after
+// committed changes that are not applied yet.
+func decideBatch(coalesce bool, batchSize int, committed int, size int) batchDecision {
+	if size == 0 {
+		// transactions without changes (of other tables) only move the checkpoint
+		return batchDecision{}
+	}
+	if !coalesce {
+		return batchDecision{flush: true}
+	}
+	decision := batchDecision{flushFirst: committed > 0 && committed+size > batchSize}
+	if decision.flushFirst {
+		committed = 0
+	}
+	decision.flush = committed+size >= batchSize
+	return decision
+}
+
+// Heartbeat records the time of a heartbeat of the transaction in progress, to be saved in
+// the replication status along with the transaction.
+func (a *Applier) Heartbeat(t time.Time) {
+	a.pendingHeartbeat = &t
+}
+
+// Lag returns the lag of the last applied batch.
+func (a *Applier) Lag() time.Duration {
+	return a.lag
+}
+
+// Discard drops the pending changes of the transaction that is not committed yet, when
+// the stream restarts from the last committed position.
+func (a *Applier) Discard() {
+	a.pending = a.pending[:a.committed]
+	a.pendingHeartbeat = nil
+}
+
+// FlushIfDue applies the committed changes if the flush interval has elapsed.
+func (a *Applier) FlushIfDue() error {
+	if time.Since(a.lastFlush) < a.settings.FlushInterval {
+		return nil
+	}
+	return a.Flush()
+}
+
+// Flush applies the committed changes and saves the checkpoint in a single transaction.
+// Changes of transactions that are not committed yet stay pending.
+func (a *Applier) Flush() error {
+	a.lastFlush = time.Now()
+	if a.position == nil || a.checkpointed {
+		return nil
+	}
+
+	start := time.Now()
+	tx, err := a.pd.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = a.applyChanges(tx, a.pending[:a.committed])
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := a.checkpoint(*a.position)
+	if err != nil {
+		return err
+	}
+	err = SaveCheckpoint(tx, a.settings.MetadataSchema, checkpoint)
+	if err != nil {
+		return err
+	}
+	passed, err := a.deletePassedTablePositions(tx, *a.position)
+	if err != nil {
+		return err
+	}
+	if err = a.saveStatus(tx); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Could not commit batch")
+	}
+	a.updateLag()
+	log.Debug().Int("changes", a.committed).Int("transactions", a.transactions).Str("gtid-set", a.position.GTIDSet).
+		Dur("lag", a.lag).Dur("duration", time.Since(start)).Msg("Applied batch")
+
+	a.pending = a.pending[a.committed:]
+	a.committed = 0
+	a.transactions = 0
+	a.checkpointed = true
+	a.saved(checkpoint, passed)
+
+	return nil
+}
+
+// checkpoint returns the checkpoint of position. It keeps the position up to which changes
+// are upserts until position has passed it.
+func (a *Applier) checkpoint(position binlog.Position) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{
+		Name:           a.settings.CheckpointName,
+		GtidSet:        position.GTIDSet,
+		BinlogFile:     position.File,
+		BinlogPosition: position.Pos,
+	}
+	if a.upsertUntil == nil {
+		return checkpoint, nil
+	}
+	passed, err := position.Includes(*a.upsertUntil)
+	if err != nil {
+		return nil, err
+	}
+	if !passed {
+		checkpoint.UpsertGtidSet = a.upsertUntil.GTIDSet
+		checkpoint.UpsertBinlogFile = a.upsertUntil.File
+		checkpoint.UpsertBinlogPosition = a.upsertUntil.Pos
+	}
+	return checkpoint, nil
+}
+
+// deletePassedTablePositions deletes the table positions that position has passed, in tx
+// along with its checkpoint, and returns their tables.
+func (a *Applier) deletePassedTablePositions(tx sqlx.Execer, position binlog.Position) ([]string, error) {
+	var passed []string
+	for table, tablePosition := range a.tablePositions {
+		ok, err := position.Includes(tablePosition)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			passed = append(passed, table)
+		}
+	}
+	if len(passed) == 0 {
+		return nil, nil
+	}
+	return passed, DeleteTablePositions(tx, a.settings.MetadataSchema, a.settings.CheckpointName, passed)
+}
+
+// saved stops the upserts once a committed checkpoint has passed their position, and the
+// skipping of the changes of the passed tables.
+func (a *Applier) saved(checkpoint *Checkpoint, passed []string) {
+	for _, table := range passed {
+		log.Info().Str("table", table).Msg("Passed the position of the loaded table, applying its changes")
+		delete(a.tablePositions, table)
+	}
+	if a.upsertUntil != nil && checkpoint.UpsertUntil() == nil {
+		log.Info().Str("position", a.upsertUntil.String()).
+			Msg("Passed the position of the resumed snapshot, inserts are no longer upserts")
+		a.upsertUntil = nil
+	}
+}
+
+// saveStatus saves the lag of the changes applied in tx.
+func (a *Applier) saveStatus(tx sqlx.Execer) error {
+	return SaveReplicationStatus(tx, a.settings.MetadataSchema, &ReplicationStatus{
+		Name:          a.settings.CheckpointName,
+		EventTime:     a.eventTime,
+		HeartbeatTime: a.heartbeat,
+	})
+}
+
+// updateLag is called once the status is saved. Each heartbeat is only saved once.
+func (a *Applier) updateLag() {
+	latest := a.eventTime
+	// heartbeats have a resolution of a microsecond, binlog events of a second
+	if a.heartbeat != nil && (latest == nil || a.heartbeat.After(*latest)) {
+		latest = a.heartbeat
+	}
+	if latest != nil {
+		a.lag = time.Since(*latest)
+	}
+	a.heartbeat = nil
+}
+
+func (a *Applier) getTable(change *binlog.RowChange) (*Table, error) {
+	// the columns change when the table is altered
+	key := fmt.Sprintf("%s/%p", change.Table, change.Columns)
+	if table, ok := a.tables[key]; ok {
+		return table, nil
+	}
+	table, err := MapTable(change.Table, change.Columns, a.settings.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	a.tables[key] = table
+	return table, nil
+}
+
+func (a *Applier) applyChanges(tx *sqlx.Tx, changes []*binlog.RowChange) error {
+	// consecutive inserts into the same table are grouped into a single statement
+	var inserts []*binlog.RowChange
+	var insertTable *Table
+	flushInserts := func() error {
+		if len(inserts) == 0 {
+			return nil
+		}
+		err := a.insert(tx, insertTable, inserts)
+		inserts = nil
+		return err
+	}
+
+	for _, change := range changes {
+		table, err := a.getTable(change)
+		if err != nil {
+			return err
+		}
+
+		// append-only tables get every change as a new row
+		if change.Operation == binlog.OperationInsert || table.isAppendOnly() {
+			if insertTable != table || !a.canGroupInsert(table, inserts, change) {
+				if err = flushInserts(); err != nil {
+					return err
+				}
+			}
+			insertTable = table
+			inserts = append(inserts, change)
+			continue
+		}
+
+		if err = flushInserts(); err != nil {
+			return err
+		}
+		switch {
+		case table.isHistory():
+			err = a.newVersion(tx, table, change)
+		case change.Operation == binlog.OperationUpdate:
+			err = a.update(tx, table, change)
+		case table.isSoftDelete():
+			err = a.softDelete(tx, table, change)
+		default:
+			err = a.delete(tx, table, change)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return flushInserts()
+}
+
+func (a *Applier) canGroupInsert(table *Table, inserts []*binlog.RowChange, change *binlog.RowChange) bool {
+	if (len(inserts)+1)*len(a.insertColumns(table)) > maxStatementParameters {
+		return false
+	}
+	// an upsert can't affect the same row twice
+	if a.upserts(table) {
+		key := a.keyString(table, change.After)
+		for _, insert := range inserts {
+			if a.keyString(table, insert.After) == key {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (a *Applier) keyString(table *Table, image []interface{}) string {
+	var parts []string
+	for _, idx := range table.primaryKeyIndexes() {
+		parts = append(parts, fmt.Sprint(image[idx]))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func (t *Table) primaryKeyIndexes() []int {
+	var res []int
+	for _, name := range t.PrimaryKey {
+		for i, c := range t.Columns {
+			if c.Name == name {
+				res = append(res, i)
+			}
+		}
+	}
+	return res
+}
+
+func (a *Applier) exec(tx *sqlx.Tx, sql_ string, args []interface{}) (int64, error) {
+	log.Trace().Str("sql", sql_).Interface("args", args).Msg("Applying change")
+	res, err := tx.Exec(sql_, args...)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Could not execute %s", sql_)
+	}
+	return res.RowsAffected()
+}
+
+// forcesUpserts returns true if inserts are upserts on all the tables that support them.
+func (a *Applier) forcesUpserts() bool {
+	return a.settings.Upsert || a.upsertUntil != nil
+}
+
+// upserts returns true if inserts into table are upserts. Soft-delete tables keep the deleted
+// rows, so inserting a row again replaces the deleted one.
+func (a *Applier) upserts(table *Table) bool {
+	if !table.CanUpsert() {
+		return false
+	}
+	return a.forcesUpserts() || table.isSoftDelete()
+}
+
+// insertColumns returns the columns an insert sets: the mapped columns, followed by the change
+// columns of append-only tables, or the start of the version of history tables.
+func (a *Applier) insertColumns(table *Table) []string {
+	var columnNames []string
+	for _, c := range table.Columns {
+		columnNames = append(columnNames, c.Name)
+	}
+	if table.isAppendOnly() {
+		columnNames = append(columnNames, OperationColumn, GtidColumn, ChangedAtColumn)
+	}
+	if table.isHistory() {
+		columnNames = append(columnNames, ValidFromColumn)
+	}
+	return append(columnNames, table.metadataColumnNames()...)
+}
+
+func (a *Applier) insert(tx *sqlx.Tx, table *Table, changes []*binlog.RowChange) error {
+	var args []interface{}
+	var rows []string
+	for _, change := range changes {
+		var placeholders []string
+		for i, v := range change.Image() {
+			args = append(args, toPsqlValue(change.Columns[i], table.Columns[i], v))
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		if table.isAppendOnly() {
+			for _, v := range []interface{}{string(change.Operation), change.GTID, change.Timestamp} {
+				args = append(args, v)
+				placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+			}
+		}
+		if table.isHistory() {
+			args = append(args, change.Timestamp)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		for _, v := range table.changeMetadataValues(change) {
+			args = append(args, v)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	sql_ := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		QuoteTableName(a.settings.Schema, table.Name), strings.Join(quoteIdentifiers(a.insertColumns(table)), ", "),
+		strings.Join(rows, ", "))
+	if a.upserts(table) {
+		sql_ += " " + table.onConflictClause()
+	}
+
+	_, err := a.exec(tx, sql_, args)
+	return err
+}
+
+func (t *Table) onConflictClause() string {
+	isKey := map[string]bool{}
+	for _, name := range t.PrimaryKey {
+		isKey[name] = true
+	}
+	var sets []string
+	for _, c := range t.Columns {
+		if !isKey[c.Name] {
+			sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", QuoteIdentifier(c.Name), QuoteIdentifier(c.Name)))
+		}
+	}
+	if t.isSoftDelete() {
+		// the row is inserted again after it was deleted
+		sets = append(sets, fmt.Sprintf("%s = NULL", QuoteIdentifier(DeletedAtColumn)))
+	}
+	for _, name := range t.metadataColumnNames() {
+		sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", QuoteIdentifier(name), QuoteIdentifier(name)))
+	}
+	if t.MetadataColumns {
+		sets = append(sets, QuoteIdentifier(SyncedAtColumn)+" = now()")
+	}
+
+	conflict := fmt.Sprintf("ON CONFLICT (%s)", strings.Join(quoteIdentifiers(t.PrimaryKey), ", "))
+	if len(sets) == 0 {
+		return conflict + " DO NOTHING"
+	}
+	return conflict + " DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// whereClause returns a condition matching image on the primary key, or if the table has none,
+// on its row hash or the full row, depending on its keyless strategy. Only the current rows
+// of soft-delete and history tables match.
+// Placeholders are numbered after the existing args.
+func (a *Applier) whereClause(table *Table, change *binlog.RowChange, image []interface{}, args []interface{}) (string, []interface{}) {
+	if table.hasRowHash() {
+		first := len(args) + 1
+		for i, v := range image {
+			args = append(args, toPsqlValue(change.Columns[i], table.Columns[i], v))
+		}
+		where := fmt.Sprintf("%s = %s", QuoteIdentifier(RowHashColumn), table.rowHashExpression(first))
+		if current := table.currentCondition(); current != "" {
+			where += " AND " + current
+		}
+		// there can be identical rows, only affect one of them
+		return fmt.Sprintf("ctid = (SELECT ctid FROM %s WHERE %s LIMIT 1)",
+			QuoteTableName(a.settings.Schema, table.Name), where), args
+	}
+
+	indexes := table.primaryKeyIndexes()
+	if len(indexes) == 0 {
+		for i := range table.Columns {
+			indexes = append(indexes, i)
+		}
+	}
+
+	// primary key columns can't be NULL, and = can use the index
+	operator := "="
+	if len(table.PrimaryKey) == 0 {
+		operator = "IS NOT DISTINCT FROM"
+	}
+
+	var conditions []string
+	for _, idx := range indexes {
+		args = append(args, toPsqlValue(change.Columns[idx], table.Columns[idx], image[idx]))
+		conditions = append(conditions, fmt.Sprintf("%s %s $%d",
+			QuoteIdentifier(table.Columns[idx].Name), operator, len(args)))
+	}
+	if current := table.currentCondition(); current != "" {
+		conditions = append(conditions, current)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	if len(table.PrimaryKey) == 0 {
+		// without a primary key, only affect one of the identical rows
+		where = fmt.Sprintf("ctid = (SELECT ctid FROM %s WHERE %s LIMIT 1)",
+			QuoteTableName(a.settings.Schema, table.Name), where)
+	}
+
+	return where, args
+}
+
+// metadataSets returns the assignments of the metadata columns of table for change, with
+// placeholders numbered after the existing args.
+func (a *Applier) metadataSets(table *Table, change *binlog.RowChange, args []interface{}) ([]string, []interface{}) {
+	if !table.MetadataColumns {
+		return nil, args
+	}
+	var sets []string
+	values := table.changeMetadataValues(change)
+	for i, name := range table.metadataColumnNames() {
+		args = append(args, values[i])
+		sets = append(sets, fmt.Sprintf("%s = $%d", QuoteIdentifier(name), len(args)))
+	}
+	return append(sets, QuoteIdentifier(SyncedAtColumn)+" = now()"), args
+}
+
+func (a *Applier) update(tx *sqlx.Tx, table *Table, change *binlog.RowChange) error {
+	var args []interface{}
+	var sets []string
+	for i, v := range change.After {
+		args = append(args, toPsqlValue(change.Columns[i], table.Columns[i], v))
+		sets = append(sets, fmt.Sprintf("%s = $%d", QuoteIdentifier(table.Columns[i].Name), len(args)))
+	}
+	metadataSets, args := a.metadataSets(table, change, args)
+	sets = append(sets, metadataSets...)
+	where, args := a.whereClause(table, change, change.Before, args)
+
+	sql_ := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		QuoteTableName(a.settings.Schema, table.Name), strings.Join(sets, ", "), where)
+	affected, err := a.exec(tx, sql_, args)
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		if a.forcesUpserts() && table.CanUpsert() {
+			return a.insert(tx, table, []*binlog.RowChange{change})
+		}
+		log.Warn().Str("table", table.Name).Str("gtid", change.GTID).Msg("Updated row not found")
+	}
+
+	return nil
+}
+
+func (a *Applier) delete(tx *sqlx.Tx, table *Table, change *binlog.RowChange) error {
+	where, args := a.whereClause(table, change, change.Before, nil)
+	sql_ := fmt.Sprintf("DELETE FROM %s WHERE %s", QuoteTableName(a.settings.Schema, table.Name), where)
+	affected, err := a.exec(tx, sql_, args)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		log.Warn().Str("table", table.Name).Str("gtid", change.GTID).Msg("Deleted row not found")
+	}
+	return nil
+}
+
+// softDelete marks the deleted row with the time of the change.
+func (a *Applier) softDelete(tx *sqlx.Tx, table *Table, change *binlog.RowChange) error {
+	sets, args := a.metadataSets(table, change, []interface{}{change.Timestamp})
+	sets = append([]string{QuoteIdentifier(DeletedAtColumn) + " = $1"}, sets...)
+	where, args := a.whereClause(table, change, change.Before, args)
+	sql_ := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		QuoteTableName(a.settings.Schema, table.Name), strings.Join(sets, ", "), where)
+	affected, err := a.exec(tx, sql_, args)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		log.Warn().Str("table", table.Name).Str("gtid", change.GTID).Msg("Deleted row not found")
+	}
+	return nil
+}
+
+// newVersion closes the current version of the updated or deleted row at the time of the
+// change, and inserts the new version of an updated row.
+func (a *Applier) newVersion(tx *sqlx.Tx, table *Table, change *binlog.RowChange) error {
+	where, args := a.whereClause(table, change, change.Before, []interface{}{change.Timestamp})
+	sql_ := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s",
+		QuoteTableName(a.settings.Schema, table.Name), QuoteIdentifier(ValidToColumn), where)
+	affected, err := a.exec(tx, sql_, args)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		log.Warn().Str("table", table.Name).Str("gtid", change.GTID).Str("operation", string(change.Operation)).
+			Msg("Current version of the row not found")
+	}
+
+	if change.Operation == binlog.OperationUpdate {
+		return a.insert(tx, table, []*binlog.RowChange{change})
+	}
+	return nil
+}
+
+// toPsqlValue converts a normalized binlog value to a value for the postgresql column,
+// mirroring the conversions done when snapshotting (see mysql.GetSelectStatement).
+func toPsqlValue(mc *mysql.ColumnMetadata, pc *Column, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch x := v.(type) {
+	case string:
+		// zero dates are loaded as NULL
+		if strings.HasPrefix(x, "0000-00-00") && contains(mc.DataType, []string{"date", "datetime", "timestamp"}) {
+			return nil
+		}
+		if pc.Type == "bytea" {
+			return []byte(x)
+		}
+		return x
+
+	case []byte:
+		if contains(mc.DataType, spatialDatatypes) {
+			wkt, err := mysqlGeometryToWKT(x)
+			if err != nil {
+				log.Warn().Err(err).Str("column", mc.ColumnName).Msg("Could not convert spatial value, loading NULL")
+				return nil
+			}
+			return wkt
+		}
+		if pc.Type == "bytea" {
+			return x
+		}
+		return string(x)
+
+	case uint64:
+		// database/sql doesn't support uint64 values with the high bit set
+		return strconv.FormatUint(x, 10)
+	}
+
+	if pc.Type == "boolean" {
+		switch x := v.(type) {
+		case int8:
+			return x != 0
+		case uint8:
+			return x != 0
+		case int64:
+			return x != 0
+		}
+	}
+
+	return v
+}
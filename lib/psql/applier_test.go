@@ -0,0 +1,106 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/binlog"
+	"testing"
+	"time"
+)
+
+func TestDecideBatch(t *testing.T) {
+	// each transaction is applied on its own without coalescing
+	assert.Equal(t, batchDecision{flush: true}, decideBatch(false, 1000, 0, 1))
+
+	// transactions are added to the batch until it is full
+	assert.Equal(t, batchDecision{}, decideBatch(true, 10, 0, 4))
+	assert.Equal(t, batchDecision{}, decideBatch(true, 10, 4, 4))
+	assert.Equal(t, batchDecision{flush: true}, decideBatch(true, 10, 8, 2))
+
+	// the batch is applied before a transaction that would overflow it
+	assert.Equal(t, batchDecision{flushFirst: true}, decideBatch(true, 10, 8, 4))
+	// a transaction larger than a batch is never split, it is a batch of its own
+	assert.Equal(t, batchDecision{flushFirst: true, flush: true}, decideBatch(true, 10, 8, 25))
+	assert.Equal(t, batchDecision{flush: true}, decideBatch(true, 10, 0, 25))
+
+	// transactions without changes only move the checkpoint
+	assert.Equal(t, batchDecision{}, decideBatch(false, 10, 0, 0))
+	assert.Equal(t, batchDecision{}, decideBatch(true, 10, 10, 0))
+}
+
+func TestApplierDiscard(t *testing.T) {
+	// nothing is due, the applier never reaches the database
+	a := NewApplier(nil, ApplierSettings{Coalesce: true, BatchSize: 10, FlushInterval: time.Hour})
+
+	a.Add(&binlog.RowChange{}, &binlog.RowChange{})
+	require.NoError(t, a.Commit(binlog.Position{File: "mysql-bin.000001", Pos: 100}, time.Now()))
+	assert.Equal(t, 2, a.committed)
+	assert.Equal(t, 1, a.transactions)
+
+	// the stream restarts in the middle of the next transaction, which is streamed again
+	a.Add(&binlog.RowChange{}, &binlog.RowChange{}, &binlog.RowChange{})
+	a.Heartbeat(time.Now())
+	a.Discard()
+	assert.Len(t, a.pending, 2)
+	assert.Nil(t, a.pendingHeartbeat)
+
+	a.Add(&binlog.RowChange{}, &binlog.RowChange{}, &binlog.RowChange{})
+	require.NoError(t, a.Commit(binlog.Position{File: "mysql-bin.000001", Pos: 200}, time.Now()))
+	assert.Equal(t, 5, a.committed)
+	assert.Equal(t, 2, a.transactions)
+
+	// discarding after a commit keeps the committed changes
+	a.Discard()
+	assert.Len(t, a.pending, 5)
+
+	// transactions without changes move the position, not the counts
+	require.NoError(t, a.Commit(binlog.Position{File: "mysql-bin.000001", Pos: 300}, time.Now()))
+	assert.Equal(t, 5, a.committed)
+	assert.Equal(t, 2, a.transactions)
+	assert.Equal(t, uint32(300), a.position.Pos)
+}
+
+func TestApplierCheckpointKeepsUpsertPosition(t *testing.T) {
+	upsertUntil := &binlog.Position{GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-9"}
+	a := NewApplier(nil, ApplierSettings{UpsertUntil: upsertUntil})
+
+	checkpoint, err := a.checkpoint(binlog.Position{GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7"})
+	assert.NoError(t, err)
+	assert.Equal(t, upsertUntil, checkpoint.UpsertUntil())
+	a.saved(checkpoint, nil)
+	assert.True(t, a.forcesUpserts())
+
+	checkpoint, err = a.checkpoint(binlog.Position{GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-9"})
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint.UpsertUntil())
+	a.saved(checkpoint, nil)
+	assert.False(t, a.forcesUpserts())
+}
+
+func TestApplierSkipsLoadedTables(t *testing.T) {
+	loaded := binlog.Position{File: "mysql-bin.000001", Pos: 200}
+	a := NewApplier(nil, ApplierSettings{Coalesce: true, BatchSize: 10, FlushInterval: time.Hour,
+		TablePositions: map[string]binlog.Position{"users": loaded}})
+
+	// the changes of users up to its position are in the loaded table already
+	a.Add(&binlog.RowChange{Table: "users"}, &binlog.RowChange{Table: "orders"})
+	require.NoError(t, a.Commit(binlog.Position{File: "mysql-bin.000001", Pos: 100}, time.Now()))
+	require.Len(t, a.pending, 1)
+	assert.Equal(t, "orders", a.pending[0].Table)
+	assert.Equal(t, 1, a.committed)
+
+	a.Add(&binlog.RowChange{Table: "users"})
+	require.NoError(t, a.Commit(loaded, time.Now()))
+	assert.Equal(t, 1, a.committed)
+
+	a.Add(&binlog.RowChange{Table: "users"})
+	require.NoError(t, a.Commit(binlog.Position{File: "mysql-bin.000001", Pos: 300}, time.Now()))
+	assert.Equal(t, 2, a.committed)
+
+	// the position is only forgotten once a checkpoint past it is saved
+	passed, err := a.deletePassedTablePositions(nil, binlog.Position{File: "mysql-bin.000001", Pos: 150})
+	assert.NoError(t, err)
+	assert.Empty(t, passed)
+	a.saved(&Checkpoint{}, []string{"users"})
+	assert.Empty(t, a.tablePositions)
+}
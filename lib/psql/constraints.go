@@ -0,0 +1,288 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"majipoor/lib/mysql"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConstraintMode decides what becomes of the mysql foreign keys and CHECK constraints.
+type ConstraintMode string
+
+const (
+	// ConstraintsNone leaves them out.
+	//
+	// This is the default.
+	ConstraintsNone ConstraintMode = "none"
+
+	// ConstraintsNotValid adds them as NOT VALID constraints, which postgresql doesn't check
+	// on the existing rows, but still checks on the rows written afterwards. Foreign keys are
+	// deferred to the end of the postgresql transaction, which only holds whole mysql
+	// transactions.
+	//
+	// Mysql doesn't always enforce them (foreign_key_checks=0, MyISAM tables), and a row it
+	// let through would stop replication: add them once the tables are loaded, and only if
+	// mysql really enforces them. Tables with foreign keys can't be reloaded, see CheckSwappable.
+	ConstraintsNotValid ConstraintMode = "not-valid"
+
+	// ConstraintsComment only documents them, in the comment of the table.
+	ConstraintsComment ConstraintMode = "comment"
+)
+
+func ParseConstraintMode(s string) (ConstraintMode, error) {
+	switch ConstraintMode(strings.ToLower(s)) {
+	case ConstraintsNone:
+		return ConstraintsNone, nil
+	case ConstraintsNotValid:
+		return ConstraintsNotValid, nil
+	case ConstraintsComment:
+		return ConstraintsComment, nil
+	default:
+		return "", errors.Errorf("Unknown constraint mode %s", s)
+	}
+}
+
+// ForeignKey is a mysql foreign key. Only references to tables of the replicated database
+// can be added to postgresql.
+type ForeignKey struct {
+	Name              string
+	Columns           []string
+	ReferencedSchema  string
+	ReferencedTable   string
+	ReferencedColumns []string
+	UpdateRule        string
+	DeleteRule        string
+}
+
+// Check is a mysql CHECK constraint. Expression is the clause with postgresql quoting, which
+// only works for the expressions both databases understand.
+type Check struct {
+	Name       string
+	Clause     string
+	Expression string
+	// Unsupported is why the check can't be added to postgresql, empty if it can
+	Unsupported string
+}
+
+// MapConstraints maps the constraints of the table, database is the replicated database.
+func (t *Table) MapConstraints(database string, constraints *mysql.TableConstraints) {
+	t.ForeignKeys, t.Checks = nil, nil
+	t.database = database
+	for _, fk := range constraints.ForeignKeys {
+		t.ForeignKeys = append(t.ForeignKeys, &ForeignKey{
+			Name:              fk.Name,
+			Columns:           fk.Columns,
+			ReferencedSchema:  fk.ReferencedSchema,
+			ReferencedTable:   fk.ReferencedTable,
+			ReferencedColumns: fk.ReferencedColumns,
+			UpdateRule:        fk.UpdateRule,
+			DeleteRule:        fk.DeleteRule,
+		})
+	}
+	for _, c := range constraints.Checks {
+		check := t.mapCheck(c)
+		switch check.Unsupported {
+		case "":
+		case redundantJSONCheck:
+			log.Debug().Str("table", t.Name).Str("check", c.Name).Msg("JSON check not added to postgresql")
+		default:
+			log.Warn().Str("table", t.Name).Str("check", c.Name).Str("reason", check.Unsupported).
+				Msg("Check not added to postgresql")
+		}
+		t.Checks = append(t.Checks, check)
+	}
+}
+
+// redundantJSONCheck is the reason the implicit json_valid() checks of the mariadb JSON columns
+// are left out.
+const redundantJSONCheck = "redundant with the jsonb type"
+
+var jsonValidRegexp = regexp.MustCompile("(?i)^\\(*\\s*json_valid\\(`([^`]+)`\\)\\s*\\)*$")
+
+func (t *Table) mapCheck(c *mysql.CheckMetadata) *Check {
+	check := &Check{Name: c.Name, Clause: c.Clause}
+	if matches := jsonValidRegexp.FindStringSubmatch(strings.TrimSpace(c.Clause)); matches != nil {
+		if column := t.column(matches[1]); column != nil && column.Type == "jsonb" {
+			check.Unsupported = redundantJSONCheck
+			return check
+		}
+	}
+	expression, err := checkExpression(c.Clause)
+	if err != nil {
+		check.Unsupported = err.Error()
+		return check
+	}
+	check.Expression = expression
+	return check
+}
+
+// charsetIntroducerRegexp matches the character set of string literals (_utf8mb4'a').
+var charsetIntroducerRegexp = regexp.MustCompile(`(^|[^\w])_[a-z0-9]+'`)
+
+// checkFunctions are the functions of CHECK clauses that mean the same in postgresql, and the
+// keywords that can be followed by a parenthesis.
+var checkFunctions = []string{
+	"abs", "coalesce", "nullif", "lower", "upper", "char_length", "character_length",
+	"and", "or", "not", "in", "is", "like", "between",
+}
+
+// checkExpression quotes the identifiers of a mysql CHECK clause for postgresql, and drops
+// the character sets of the string literals. It fails on the functions postgresql doesn't have.
+func checkExpression(clause string) (string, error) {
+	var sb strings.Builder
+	var word strings.Builder
+	// lastWord is the word before the current character, outside of strings and identifiers
+	lastWord := ""
+	inString, inIdentifier := false, false
+	for _, r := range clause {
+		isWordRune := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		switch {
+		case inString:
+			inString = r != '\''
+		case inIdentifier:
+			if r == '`' {
+				inIdentifier = false
+				r = '"'
+			}
+		case isWordRune:
+			word.WriteRune(r)
+		default:
+			if word.Len() > 0 {
+				lastWord = strings.ToLower(word.String())
+				word.Reset()
+			}
+			switch {
+			case r == '(' && lastWord != "" && !contains(lastWord, checkFunctions):
+				return "", errors.Errorf("mysql function %s", lastWord)
+			case r == '\'':
+				inString = true
+			case r == '`':
+				inIdentifier = true
+				r = '"'
+			}
+			if r != ' ' {
+				lastWord = ""
+			}
+		}
+		sb.WriteRune(r)
+	}
+	return charsetIntroducerRegexp.ReplaceAllString(sb.String(), "$1'"), nil
+}
+
+// mysqlDefinition returns the foreign key as declared in mysql.
+func (fk *ForeignKey) mysqlDefinition() string {
+	return fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s.%s (%s) ON UPDATE %s ON DELETE %s",
+		fk.Name, strings.Join(fk.Columns, ", "), fk.ReferencedSchema, fk.ReferencedTable,
+		strings.Join(fk.ReferencedColumns, ", "), fk.UpdateRule, fk.DeleteRule)
+}
+
+// referentialAction returns the postgresql action of a mysql rule. RESTRICT can't be
+// deferred, and is the same as NO ACTION in mysql.
+func referentialAction(rule string) string {
+	if rule == "RESTRICT" {
+		return "NO ACTION"
+	}
+	return rule
+}
+
+// sameColumns returns true if a and b have the same columns, in any order.
+func sameColumns(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isReferenceable returns true if postgresql can reference columns of the table, which
+// needs a primary key or a unique index over exactly these columns.
+func (t *Table) isReferenceable(columns []string) bool {
+	if len(t.PrimaryKey) > 0 && !t.isHistory() && sameColumns(t.PrimaryKey, columns) {
+		return true
+	}
+	for _, index := range t.Indexes {
+		if index.Unique && index.Where == "" && index.Unsupported == "" &&
+			sameColumns(index.Expressions, quoteIdentifiers(columns)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsupportedReason returns why the foreign key can't be added to postgresql, "" if it can.
+func (t *Table) unsupportedReason(fk *ForeignKey, tables map[string]*Table) string {
+	if fk.ReferencedSchema != t.database {
+		return "references another database"
+	}
+	referenced, ok := tables[fk.ReferencedTable]
+	if !ok {
+		return "references a table that is not replicated"
+	}
+	if !referenced.isReferenceable(fk.ReferencedColumns) {
+		return "references columns without primary key or unique index in postgresql"
+	}
+	return ""
+}
+
+// constraintStatements returns the statements adding the constraints of the table in mode.
+// tables are the tables of the schema, by name. NOT VALID constraints are replaced if they
+// exist, so that the statements can be run again.
+func (t *Table) constraintStatements(schema string, mode ConstraintMode, tables map[string]*Table) []string {
+	tableName := QuoteTableName(schema, t.Name)
+	switch mode {
+	case ConstraintsNotValid:
+		var statements []string
+		for _, fk := range t.ForeignKeys {
+			if reason := t.unsupportedReason(fk, tables); reason != "" {
+				log.Warn().Str("table", t.Name).Str("foreign-key", fk.Name).Str("reason", reason).
+					Msg("Foreign key not added to postgresql")
+				continue
+			}
+			statements = append(statements, fmt.Sprintf(
+				"ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s, ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) ON UPDATE %s ON DELETE %s DEFERRABLE INITIALLY DEFERRED NOT VALID",
+				tableName, QuoteIdentifier(fk.Name), QuoteIdentifier(fk.Name),
+				strings.Join(quoteIdentifiers(fk.Columns), ", "), QuoteTableName(schema, fk.ReferencedTable),
+				strings.Join(quoteIdentifiers(fk.ReferencedColumns), ", "),
+				referentialAction(fk.UpdateRule), referentialAction(fk.DeleteRule)))
+		}
+		for _, c := range t.Checks {
+			if c.Unsupported != "" {
+				continue
+			}
+			statements = append(statements, fmt.Sprintf(
+				"ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s, ADD CONSTRAINT %s CHECK (%s) NOT VALID",
+				tableName, QuoteIdentifier(c.Name), QuoteIdentifier(c.Name), c.Expression))
+		}
+		return statements
+
+	case ConstraintsComment:
+		var definitions []string
+		for _, fk := range t.ForeignKeys {
+			definitions = append(definitions, fk.mysqlDefinition())
+		}
+		for _, c := range t.Checks {
+			definitions = append(definitions, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", c.Name, c.Clause))
+		}
+		if len(definitions) == 0 {
+			return nil
+		}
+		return []string{
+			fmt.Sprintf("COMMENT ON TABLE %s IS %s", tableName,
+				QuoteLiteral("mysql constraints: "+strings.Join(definitions, "; "))),
+		}
+
+	case ConstraintsNone:
+	}
+	return nil
+}
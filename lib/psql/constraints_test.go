@@ -0,0 +1,78 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func TestMapConstraints(t *testing.T) {
+	userColumns := []*mysql.ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20)", ColumnKey: "PRI"},
+	}
+	postColumns := []*mysql.ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20)", ColumnKey: "PRI"},
+		{ColumnName: "author", DataType: "bigint", ColumnType: "bigint(20)"},
+		{ColumnName: "status", DataType: "varchar", ColumnType: "varchar(20)", CharacterMaximumLength: intPtr(20)},
+	}
+	users, err := MapTable("wp_users", userColumns, MappingSettings{})
+	require.NoError(t, err)
+	posts, err := MapTable("wp_posts", postColumns, MappingSettings{})
+	require.NoError(t, err)
+	posts.MapConstraints("wp", &mysql.TableConstraints{
+		ForeignKeys: []*mysql.ForeignKeyMetadata{
+			{Name: "fk_author", Columns: []string{"author"}, ReferencedSchema: "wp", ReferencedTable: "wp_users",
+				ReferencedColumns: []string{"id"}, UpdateRule: "RESTRICT", DeleteRule: "CASCADE"},
+			{Name: "fk_other", Columns: []string{"author"}, ReferencedSchema: "other", ReferencedTable: "users",
+				ReferencedColumns: []string{"id"}, UpdateRule: "NO ACTION", DeleteRule: "NO ACTION"},
+		},
+		Checks: []*mysql.CheckMetadata{{Name: "chk_status", Clause: "(`status` in (_utf8mb4'draft',_utf8mb4'pub`lish'))"}},
+	})
+
+	settings := CreateSchemaSettings{Schema: "wp", Tables: []*Table{users, posts}, Constraints: ConstraintsNotValid, ConstraintsOnly: true}
+	assert.Equal(t, []string{
+		`ALTER TABLE "wp"."wp_posts" DROP CONSTRAINT IF EXISTS "fk_author", ADD CONSTRAINT "fk_author" FOREIGN KEY ("author") REFERENCES "wp"."wp_users" ("id") ON UPDATE NO ACTION ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED NOT VALID`,
+		`ALTER TABLE "wp"."wp_posts" DROP CONSTRAINT IF EXISTS "chk_status", ADD CONSTRAINT "chk_status" CHECK (("status" in ('draft','pub` + "`" + `lish'))) NOT VALID`,
+	}, GetCreateSchemaStatements(settings))
+
+	settings.Constraints = ConstraintsComment
+	statements := GetCreateSchemaStatements(settings)
+	require.Len(t, statements, 1)
+	assert.Contains(t, statements[0], `COMMENT ON TABLE "wp"."wp_posts" IS 'mysql constraints: CONSTRAINT fk_author FOREIGN KEY (author) REFERENCES wp.wp_users (id)`)
+
+	_, err = ParseConstraintMode("strict")
+	assert.Error(t, err)
+}
+
+func TestMapChecks(t *testing.T) {
+	columns := []*mysql.ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20)", ColumnKey: "PRI"},
+		{ColumnName: "attributes", DataType: "json", ColumnType: "json"},
+		{ColumnName: "price", DataType: "int", ColumnType: "int(11)"},
+	}
+	table, err := MapTable("wp_products", columns, MappingSettings{})
+	require.NoError(t, err)
+	table.MapConstraints("wp", &mysql.TableConstraints{
+		Checks: []*mysql.CheckMetadata{
+			// mariadb adds it to every JSON column
+			{Name: "attributes", Clause: "json_valid(`attributes`)"},
+			{Name: "chk_price", Clause: "(`price` >= 0 and abs(`price`) < 1000000)"},
+			{Name: "chk_name", Clause: "(regexp_like(`name`, _utf8mb4'^[a-z(]+$'))"},
+		},
+	})
+
+	require.Len(t, table.Checks, 3)
+	assert.Equal(t, redundantJSONCheck, table.Checks[0].Unsupported)
+	assert.Equal(t, "", table.Checks[1].Unsupported)
+	assert.Equal(t, "mysql function regexp_like", table.Checks[2].Unsupported)
+	assert.Equal(t, []string{
+		`ALTER TABLE "wp"."wp_products" DROP CONSTRAINT IF EXISTS "chk_price", ADD CONSTRAINT "chk_price" CHECK (("price" >= 0 and abs("price") < 1000000)) NOT VALID`,
+	}, GetCreateSchemaStatements(CreateSchemaSettings{
+		Schema: "wp", Tables: []*Table{table}, Constraints: ConstraintsNotValid, ConstraintsOnly: true,
+	}))
+
+	expression, err := checkExpression("(`status` in (_utf8mb4'a(b', _utf8mb4'c'))")
+	require.NoError(t, err)
+	assert.Equal(t, `("status" in ('a(b', 'c'))`, expression)
+}
@@ -0,0 +1,75 @@
+package psql
+
+import (
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// CopyRows streams rows into schema.table with COPY ... FROM STDIN, inside tx.
+// rows has to return the columns of table in order, as text
+// (see mysql.GetSelectStatement). Binary and spatial columns are expected to be hex encoded.
+// The metadata columns of the table, if any, are filled from metadata.
+func CopyRows(tx *sqlx.Tx, schema string, table *Table, rows *sql.Rows, metadata *RowMetadata) (int64, error) {
+	var columnNames []string
+	for _, c := range table.Columns {
+		columnNames = append(columnNames, c.Name)
+	}
+	metadataColumns, metadataValues := table.snapshotMetadataValues(metadata)
+	columnNames = append(columnNames, metadataColumns...)
+
+	stmt, err := tx.Prepare(pq.CopyInSchema(schema, table.Name, columnNames...))
+	if err != nil {
+		return 0, errors.Wrapf(err, "Could not prepare COPY into %s.%s", schema, table.Name)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	values := make([]sql.NullString, len(table.Columns))
+	dest := make([]interface{}, len(table.Columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	args := make([]interface{}, len(columnNames))
+	copy(args[len(table.Columns):], metadataValues)
+
+	var count int64
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return count, errors.Wrap(err, "Could not scan row")
+		}
+		for i, v := range values {
+			if !v.Valid {
+				args[i] = nil
+			} else if table.Columns[i].Type == "bytea" {
+				args[i] = `\x` + v.String
+			} else if table.Columns[i].Spatial {
+				args[i], err = hexGeometryToWKT(v.String)
+				if err != nil {
+					return count, errors.Wrapf(err, "Could not convert %s.%s", table.Name, table.Columns[i].Name)
+				}
+			} else {
+				args[i] = v.String
+			}
+		}
+		_, err = stmt.Exec(args...)
+		if err != nil {
+			return count, errors.Wrapf(err, "Could not copy row into %s.%s", schema, table.Name)
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return count, errors.Wrap(err, "Could not read rows")
+	}
+
+	// an Exec without arguments flushes the COPY
+	_, err = stmt.Exec()
+	if err != nil {
+		return count, errors.Wrapf(err, "Could not finish COPY into %s.%s", schema, table.Name)
+	}
+
+	return count, nil
+}
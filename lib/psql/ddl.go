@@ -0,0 +1,149 @@
+package psql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"majipoor/lib/binlog"
+	"time"
+)
+
+// psqlLiteral returns v, as returned by toPsqlValue, as a postgresql literal.
+func psqlLiteral(v interface{}) *string {
+	var s string
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		s = QuoteLiteral(`\x` + hex.EncodeToString(x))
+	default:
+		s = QuoteLiteral(fmt.Sprint(x))
+	}
+	return &s
+}
+
+// addColumnsStatements returns the statements adding the columns of change to table, which is
+// mapped from the columns after the change.
+//
+// The existing rows get the value they have in mysql through a temporary default.
+func (t *Table) addColumnsStatements(schema string, change *binlog.SchemaChange) ([]string, error) {
+	var statements []string
+	for _, added := range change.AddedColumns {
+		var column *Column
+		for i, c := range change.Columns {
+			if c.ColumnName == added.Column.ColumnName {
+				column = t.Columns[i]
+			}
+		}
+		if column == nil {
+			return nil, errors.Errorf("Column %s not found in %s", added.Column.ColumnName, t.Name)
+		}
+
+		definition := *column
+		if added.Value != nil {
+			definition.Default = psqlLiteral(toPsqlValue(added.Column, column, *added.Value))
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s",
+			QuoteTableName(schema, t.Name), definition.ColumnDefinition()))
+		if definition.Default != nil {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT",
+				QuoteTableName(schema, t.Name), QuoteIdentifier(column.Name)))
+		}
+	}
+
+	if t.hasRowHash() && len(statements) > 0 {
+		statements = append(statements, t.rehashStatements(schema)...)
+	}
+	return statements, nil
+}
+
+// ApplySchemaChange applies the committed changes, then the schema change along with the
+// new schema version and the checkpoint of position in a single transaction.
+func (a *Applier) ApplySchemaChange(change *binlog.SchemaChange, position binlog.Position) error {
+	if change.Unsupported != "" {
+		return errors.Errorf("Unsupported change of table %s: %s", change.Table, change.Unsupported)
+	}
+	if err := a.Flush(); err != nil {
+		return err
+	}
+
+	table, err := MapTable(change.Table, change.Columns, a.settings.Mapping)
+	if err != nil {
+		return err
+	}
+
+	var statements []string
+	if change.Created {
+		exists, err := a.pd.TableExists(a.settings.Schema, table.Name)
+		if err != nil {
+			return errors.Wrap(err, "Could not check if table exists")
+		}
+		if exists {
+			log.Warn().Str("table", table.Name).Msg("Created table already exists in postgresql, keeping it")
+		} else {
+			if table.hasRowHash() {
+				statements = append(statements, getRowHashFunctionStatements(table.metadataSchema)...)
+			}
+			statements = append(statements, table.CreateTableStatements(a.settings.Schema)...)
+		}
+	} else {
+		statements, err = table.addColumnsStatements(a.settings.Schema, change)
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	tx, err := a.pd.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, sql_ := range statements {
+		log.Debug().Str("sql", sql_).Msg("Executing statement")
+		if _, err = tx.Exec(sql_); err != nil {
+			return errors.Wrapf(err, "Could not execute %s", sql_)
+		}
+	}
+
+	err = RecordSchemaVersion(tx, a.settings.MetadataSchema, change.Database, change.Table, position, change.Columns)
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := a.checkpoint(position)
+	if err != nil {
+		return err
+	}
+	err = SaveCheckpoint(tx, a.settings.MetadataSchema, checkpoint)
+	if err != nil {
+		return err
+	}
+	passed, err := a.deletePassedTablePositions(tx, position)
+	if err != nil {
+		return err
+	}
+	a.eventTime = &change.Timestamp
+	if err = a.saveStatus(tx); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Could not commit schema change")
+	}
+	a.updateLag()
+	log.Info().Str("table", table.Name).Bool("created", change.Created).
+		Int("added-columns", len(change.AddedColumns)).Str("gtid", change.GTID).
+		Dur("duration", time.Since(start)).Msg("Applied schema change")
+
+	a.position = &position
+	a.checkpointed = true
+	a.saved(checkpoint, passed)
+
+	return nil
+}
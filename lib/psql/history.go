@@ -0,0 +1,55 @@
+package psql
+
+import (
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"majipoor/lib/binlog"
+	"majipoor/lib/mysql"
+)
+
+// SchemaHistory is the binlog.SchemaHistory recorded in the metadata schema.
+type SchemaHistory struct {
+	pd             *PsqlDB
+	metadataSchema string
+}
+
+func NewSchemaHistory(pd *PsqlDB, metadataSchema string) *SchemaHistory {
+	return &SchemaHistory{pd: pd, metadataSchema: metadataSchema}
+}
+
+func (h *SchemaHistory) GetSchemaVersions(database string, table string) ([]*binlog.SchemaVersion, error) {
+	records, err := h.pd.GetSchemaVersions(h.metadataSchema, database, table)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*binlog.SchemaVersion
+	for _, r := range records {
+		var columns []*mysql.ColumnMetadata
+		if err = json.Unmarshal(r.Columns, &columns); err != nil {
+			return nil, errors.Wrapf(err, "Could not decode schema version %d of %s", r.ID, table)
+		}
+		versions = append(versions, &binlog.SchemaVersion{
+			Position: binlog.Position{GTIDSet: r.GtidSet, File: r.BinlogFile, Pos: r.BinlogPosition},
+			Columns:  columns,
+		})
+	}
+	return versions, nil
+}
+
+// RecordSchemaVersion records the columns of a table from position on.
+func RecordSchemaVersion(tx sqlx.Execer, metadataSchema string, database string, table string,
+	position binlog.Position, columns []*mysql.ColumnMetadata) error {
+	b, err := json.Marshal(columns)
+	if err != nil {
+		return errors.Wrapf(err, "Could not encode columns of %s", table)
+	}
+	return SaveSchemaVersion(tx, metadataSchema, &SchemaVersionRecord{
+		Database:       database,
+		Table:          table,
+		GtidSet:        position.GTIDSet,
+		BinlogFile:     position.File,
+		BinlogPosition: position.Pos,
+		Columns:        b,
+	})
+}
@@ -0,0 +1,164 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"hash/crc32"
+	"majipoor/lib/mysql"
+	"strings"
+	"unicode/utf8"
+)
+
+// Index is a postgresql index recreated from a mysql index. The primary key is part of the
+// CREATE TABLE statement, it is never an Index.
+//
+// Prefix indexes index the same prefix with left() (substring() for bytea), fulltext indexes
+// become GIN indexes of the 'simple' text search configuration (the MATCH queries have to be
+// rewritten to use them), and spatial indexes become GiST indexes of the PostGIS geometry.
+type Index struct {
+	// Name is the name of the mysql index
+	Name   string
+	Unique bool
+	// Method is the index access method, empty for btree
+	Method string
+	// Expressions are the indexed columns or expressions, quoted
+	Expressions []string
+	// Where is the condition of a partial index
+	Where string
+	// Unsupported is why the index can't be recreated, empty if it can
+	Unsupported string
+}
+
+// MapIndexes maps the secondary mysql indexes of the table. Indexes that postgresql can't
+// express are skipped, with a warning.
+//
+// The unique indexes of soft-delete and history tables only apply to the current rows, and
+// append-only tables, which hold several versions of each row, get non unique indexes.
+func (t *Table) MapIndexes(indexes []*mysql.IndexMetadata) {
+	t.Indexes = nil
+	for _, i := range indexes {
+		if i.IsPrimary() {
+			continue
+		}
+		index := t.mapIndex(i)
+		if index.Unsupported != "" {
+			log.Warn().Str("table", t.Name).Str("index", i.Name).Str("reason", index.Unsupported).
+				Msg("Index not recreated in postgresql")
+		}
+		t.Indexes = append(t.Indexes, index)
+	}
+}
+
+func (t *Table) mapIndex(i *mysql.IndexMetadata) *Index {
+	index := &Index{Name: i.Name, Unique: i.Unique && !t.isAppendOnly()}
+	if index.Unique {
+		index.Where = t.currentCondition()
+	}
+	if i.Functional {
+		index.Unsupported = "functional index"
+		return index
+	}
+
+	var columns []*Column
+	for _, name := range i.Columns {
+		c := t.column(name)
+		if c == nil {
+			index.Unsupported = fmt.Sprintf("unknown column %s", name)
+			return index
+		}
+		columns = append(columns, c)
+	}
+
+	switch {
+	case i.IsFulltext():
+		var parts []string
+		for _, c := range columns {
+			parts = append(parts, fmt.Sprintf("coalesce(%s, '')", QuoteIdentifier(c.Name)))
+		}
+		index.Method = "gin"
+		index.Expressions = []string{
+			fmt.Sprintf("to_tsvector('simple'::regconfig, %s)", strings.Join(parts, " || ' ' || ")),
+		}
+
+	case i.IsSpatial():
+		if columns[0].Type != "geometry" {
+			index.Unsupported = "spatial index of a column not mapped to geometry"
+			return index
+		}
+		index.Method = "gist"
+		index.Expressions = []string{QuoteIdentifier(columns[0].Name)}
+
+	default:
+		for n, c := range columns {
+			expression := QuoteIdentifier(c.Name)
+			if n < len(i.Prefixes) && i.Prefixes[n] > 0 {
+				if c.Type == "bytea" {
+					expression = fmt.Sprintf("substring(%s FROM 1 FOR %d)", expression, i.Prefixes[n])
+				} else {
+					expression = fmt.Sprintf("left(%s, %d)", expression, i.Prefixes[n])
+				}
+			}
+			if n < len(i.Descending) && i.Descending[n] {
+				expression += " DESC"
+			}
+			index.Expressions = append(index.Expressions, expression)
+		}
+	}
+	return index
+}
+
+func (t *Table) column(name string) *Column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// maxIdentifierLength is the length postgresql truncates identifiers to (NAMEDATALEN - 1)
+const maxIdentifierLength = 63
+
+// indexName returns the name of the postgresql index. Index names are unique per schema in
+// postgresql, and per table in mysql. Names that are too long are truncated, and end with a
+// hash of the full name, so that truncated names don't collide.
+func (t *Table) indexName(index *Index) string {
+	name := t.Name + "_" + index.Name + "_idx"
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+	hash := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(name)))
+	prefix := name[:maxIdentifierLength-len(hash)-1]
+	// don't cut a multibyte character in two
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + "_" + hash
+}
+
+// CreateIndexStatements returns the statements creating the indexes of the table. They can
+// be run again, and should run after loading the rows.
+func (t *Table) CreateIndexStatements(schema string) []string {
+	var statements []string
+	for _, index := range t.Indexes {
+		if index.Unsupported != "" {
+			continue
+		}
+		unique := ""
+		if index.Unique {
+			unique = "UNIQUE "
+		}
+		using := ""
+		if index.Method != "" {
+			using = "USING " + index.Method + " "
+		}
+		sql_ := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s %s(%s)", unique,
+			QuoteIdentifier(t.indexName(index)), QuoteTableName(schema, t.Name), using,
+			strings.Join(index.Expressions, ", "))
+		if index.Where != "" {
+			sql_ += " WHERE " + index.Where
+		}
+		statements = append(statements, sql_)
+	}
+	return statements
+}
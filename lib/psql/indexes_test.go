@@ -0,0 +1,57 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func TestMapIndexes(t *testing.T) {
+	columns := []*mysql.ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20)", ColumnKey: "PRI"},
+		{ColumnName: "email", DataType: "varchar", ColumnType: "varchar(200)", CharacterMaximumLength: intPtr(200)},
+		{ColumnName: "bio", DataType: "text", ColumnType: "text"},
+		{ColumnName: "location", DataType: "point", ColumnType: "point"},
+	}
+	indexes := []*mysql.IndexMetadata{
+		{Name: "PRIMARY", Unique: true, Columns: []string{"id"}, Type: "BTREE", Prefixes: []int{0}, Descending: []bool{false}},
+		{Name: "email", Unique: true, Columns: []string{"email"}, Type: "BTREE", Prefixes: []int{0}, Descending: []bool{false}},
+		{Name: "bio_email", Columns: []string{"bio", "email"}, Type: "BTREE", Prefixes: []int{10, 0}, Descending: []bool{false, true}},
+		{Name: "bio_ft", Columns: []string{"bio"}, Type: "FULLTEXT", Prefixes: []int{0}, Descending: []bool{false}},
+		{Name: "location", Columns: []string{"location"}, Type: "SPATIAL", Prefixes: []int{0}, Descending: []bool{false}},
+		{Name: "lower_email", Columns: []string{""}, Type: "BTREE", Prefixes: []int{0}, Descending: []bool{false}, Functional: true},
+	}
+
+	table, err := MapTable("wp_users", columns, MappingSettings{TableMode: TableModeSoftDelete})
+	require.NoError(t, err)
+	table.MapIndexes(indexes)
+	require.Len(t, table.Indexes, 5)
+	assert.Equal(t, "spatial index of a column not mapped to geometry", table.Indexes[3].Unsupported)
+	assert.Equal(t, "functional index", table.Indexes[4].Unsupported)
+	assert.Equal(t, []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS "wp_users_email_idx" ON "majipoor"."wp_users" ("email") WHERE "_majipoor_deleted_at" IS NULL`,
+		`CREATE INDEX IF NOT EXISTS "wp_users_bio_email_idx" ON "majipoor"."wp_users" (left("bio", 10), "email" DESC)`,
+		`CREATE INDEX IF NOT EXISTS "wp_users_bio_ft_idx" ON "majipoor"."wp_users" USING gin (to_tsvector('simple'::regconfig, coalesce("bio", '')))`,
+	}, table.CreateIndexStatements("majipoor"))
+
+	table, err = MapTable("wp_users", columns, MappingSettings{TypeMappingMode: TypeMappingStrict})
+	require.NoError(t, err)
+	table.MapIndexes(indexes)
+	assert.Equal(t, "", table.Indexes[3].Unsupported)
+	assert.Equal(t, `CREATE INDEX IF NOT EXISTS "wp_users_location_idx" ON "majipoor"."wp_users" USING gist ("location")`,
+		table.CreateIndexStatements("majipoor")[3])
+}
+
+func TestIndexName(t *testing.T) {
+	table := &Table{Name: "wp_users"}
+	assert.Equal(t, "wp_users_user_email_idx", table.indexName(&Index{Name: "user_email"}))
+
+	table = &Table{Name: "wp_woocommerce_downloadable_product_permissions"}
+	a := table.indexName(&Index{Name: "download_order_key_product"})
+	b := table.indexName(&Index{Name: "download_order_product"})
+	assert.Len(t, a, maxIdentifierLength)
+	assert.Len(t, b, maxIdentifierLength)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a, table.indexName(&Index{Name: "download_order_key_product"}))
+}
@@ -0,0 +1,185 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// KeylessStrategy decides how the changes to a mysql table without a primary key are applied,
+// as there is no reliable way to identify the row they affect.
+type KeylessStrategy string
+
+const (
+	// KeylessFullRow matches updated and deleted rows on all their columns. Only one of
+	// several identical rows is affected, like in mysql. Every match is a sequential scan.
+	//
+	// This is the default.
+	KeylessFullRow KeylessStrategy = "full-row"
+
+	// KeylessRowHash adds an indexed _majipoor_row_hash column, computed by a trigger
+	// from all the other columns, and matches updated and deleted rows on it.
+	KeylessRowHash KeylessStrategy = "row-hash"
+
+	// KeylessAppendOnly never updates or deletes rows. Every change is inserted as a new row,
+	// along with its operation, GTID and time in _majipoor_operation, _majipoor_gtid and
+	// _majipoor_changed_at. Snapshotted rows have the operation "snapshot".
+	KeylessAppendOnly KeylessStrategy = "append-only"
+)
+
+const (
+	RowHashColumn   = "_majipoor_row_hash"
+	OperationColumn = "_majipoor_operation"
+	GtidColumn      = "_majipoor_gtid"
+	ChangedAtColumn = "_majipoor_changed_at"
+
+	rowHashFunction = "row_hash"
+	rowHashTrigger  = "_majipoor_row_hash"
+)
+
+func ParseKeylessStrategy(s string) (KeylessStrategy, error) {
+	switch KeylessStrategy(strings.ToLower(s)) {
+	case KeylessFullRow:
+		return KeylessFullRow, nil
+	case KeylessRowHash:
+		return KeylessRowHash, nil
+	case KeylessAppendOnly:
+		return KeylessAppendOnly, nil
+	default:
+		return "", errors.Errorf("Unknown keyless table strategy %s", s)
+	}
+}
+
+// MappingSettings holds everything that decides how a mysql table is mapped to postgresql.
+// The snapshot, the applier and create-schema have to use the same settings.
+type MappingSettings struct {
+	TypeMappingMode TypeMappingMode
+	KeylessStrategy KeylessStrategy
+	// TableMode is the mode of the tables that are not in TableModes
+	TableMode  TableMode
+	TableModes map[string]TableMode
+	// MetadataColumns adds the replication metadata columns to every table
+	MetadataColumns bool
+	// Indexes recreates the secondary mysql indexes, see Table.MapIndexes
+	Indexes bool
+	// MetadataSchema holds the trigger function of the row-hash strategy
+	MetadataSchema string
+}
+
+// ParseMappingSettings parses the settings, tableModes are table=mode pairs.
+func ParseMappingSettings(typeMapping string, keylessStrategy string, tableMode string, tableModes []string,
+	metadataColumns bool, metadataSchema string) (MappingSettings, error) {
+	mode, err := ParseTypeMappingMode(typeMapping)
+	if err != nil {
+		return MappingSettings{}, err
+	}
+	strategy, err := ParseKeylessStrategy(keylessStrategy)
+	if err != nil {
+		return MappingSettings{}, err
+	}
+	defaultTableMode, err := ParseTableMode(tableMode)
+	if err != nil {
+		return MappingSettings{}, err
+	}
+	modes, err := ParseTableModes(tableModes)
+	if err != nil {
+		return MappingSettings{}, err
+	}
+	return MappingSettings{
+		TypeMappingMode: mode,
+		KeylessStrategy: strategy,
+		TableMode:       defaultTableMode,
+		TableModes:      modes,
+		MetadataColumns: metadataColumns,
+		MetadataSchema:  metadataSchema,
+	}, nil
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// applyKeylessStrategy adds the system columns of the strategy to a table without primary key.
+func (t *Table) applyKeylessStrategy(settings MappingSettings) {
+	t.KeylessStrategy = settings.KeylessStrategy
+	if t.KeylessStrategy == "" {
+		t.KeylessStrategy = KeylessFullRow
+	}
+
+	switch t.KeylessStrategy {
+	case KeylessRowHash:
+		t.SystemColumns = append(t.SystemColumns, &Column{Name: RowHashColumn, Type: "text"})
+		t.metadataSchema = settings.MetadataSchema
+	case KeylessAppendOnly:
+		t.SystemColumns = append(t.SystemColumns,
+			&Column{Name: OperationColumn, Type: "text", NotNull: true, Default: stringPtr("'snapshot'")},
+			&Column{Name: GtidColumn, Type: "text"},
+			&Column{Name: ChangedAtColumn, Type: "timestamptz", NotNull: true, Default: stringPtr("now()")},
+		)
+	case KeylessFullRow:
+	}
+}
+
+func (t *Table) isAppendOnly() bool {
+	return len(t.PrimaryKey) == 0 && t.KeylessStrategy == KeylessAppendOnly
+}
+
+func (t *Table) hasRowHash() bool {
+	return len(t.PrimaryKey) == 0 && t.KeylessStrategy == KeylessRowHash
+}
+
+// The row hash is the md5 of the text values of the columns (as returned by to_jsonb, so
+// that it doesn't depend on the session settings), separated by tabs, with \N for NULL.
+// The trigger computes it from the stored row, the applier from the values of the binlog
+// cast to the column types, which gives the same text values.
+
+func getRowHashFunctionStatements(metadataSchema string) []string {
+	return []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdentifier(metadataSchema)),
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	NEW.%s := md5((
+		SELECT string_agg(coalesce(to_jsonb(NEW) ->> a, '\N'), E'\t' ORDER BY i)
+		FROM unnest(TG_ARGV) WITH ORDINALITY AS args(a, i)
+	));
+	RETURN NEW;
+END
+$$`, QuoteTableName(metadataSchema, rowHashFunction), QuoteIdentifier(RowHashColumn)),
+	}
+}
+
+func (t *Table) rowHashStatements(schema string) []string {
+	return []string{
+		fmt.Sprintf("CREATE INDEX ON %s (%s)", QuoteTableName(schema, t.Name), QuoteIdentifier(RowHashColumn)),
+		t.rowHashTriggerStatement(schema),
+	}
+}
+
+func (t *Table) rowHashTriggerStatement(schema string) string {
+	var columns []string
+	for _, c := range t.Columns {
+		columns = append(columns, QuoteLiteral(c.Name))
+	}
+	return fmt.Sprintf("CREATE TRIGGER %s BEFORE INSERT OR UPDATE ON %s FOR EACH ROW EXECUTE FUNCTION %s(%s)",
+		QuoteIdentifier(rowHashTrigger), QuoteTableName(schema, t.Name),
+		QuoteTableName(t.metadataSchema, rowHashFunction), strings.Join(columns, ", "))
+}
+
+// rehashStatements recreates the trigger with the current columns, and recomputes the hash of
+// every row, after columns were added.
+func (t *Table) rehashStatements(schema string) []string {
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", QuoteIdentifier(rowHashTrigger), QuoteTableName(schema, t.Name)),
+		t.rowHashTriggerStatement(schema),
+		fmt.Sprintf("UPDATE %s SET %s = NULL", QuoteTableName(schema, t.Name), QuoteIdentifier(RowHashColumn)),
+	}
+}
+
+// rowHashExpression returns the row hash of the values of the placeholders, starting at $first.
+func (t *Table) rowHashExpression(first int) string {
+	var values []string
+	for i, c := range t.Columns {
+		values = append(values, fmt.Sprintf(`coalesce(to_jsonb($%d::%s) #>> '{}', '\N')`, first+i, c.Type))
+	}
+	return fmt.Sprintf(`md5(concat_ws(E'\t', %s))`, strings.Join(values, ", "))
+}
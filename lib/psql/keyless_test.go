@@ -0,0 +1,41 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func TestMapKeylessTable(t *testing.T) {
+	columns := []*mysql.ColumnMetadata{
+		{ColumnName: "meta_key", DataType: "varchar", ColumnType: "varchar(255)", CharacterMaximumLength: intPtr(255)},
+		{ColumnName: "meta_value", DataType: "longtext", ColumnType: "longtext"},
+	}
+
+	table, err := MapTable("wp_meta", columns, MappingSettings{KeylessStrategy: KeylessFullRow})
+	require.NoError(t, err)
+	assert.Empty(t, table.SystemColumns)
+	assert.Len(t, table.CreateTableStatements("majipoor"), 1)
+
+	table, err = MapTable("wp_meta", columns, MappingSettings{KeylessStrategy: KeylessRowHash, MetadataSchema: "meta"})
+	require.NoError(t, err)
+	require.Len(t, table.SystemColumns, 1)
+	assert.Equal(t, RowHashColumn, table.SystemColumns[0].Name)
+	statements := table.CreateTableStatements("majipoor")
+	require.Len(t, statements, 3)
+	assert.Contains(t, statements[2], `EXECUTE FUNCTION "meta"."row_hash"('meta_key', 'meta_value')`)
+
+	table, err = MapTable("wp_meta", columns, MappingSettings{KeylessStrategy: KeylessAppendOnly})
+	require.NoError(t, err)
+	assert.True(t, table.isAppendOnly())
+	assert.False(t, table.CanUpsert())
+	assert.Len(t, table.AllColumns(), 5)
+
+	// tables with a primary key ignore the strategy
+	columns[0].ColumnKey = "PRI"
+	table, err = MapTable("wp_meta", columns, MappingSettings{KeylessStrategy: KeylessAppendOnly})
+	require.NoError(t, err)
+	assert.False(t, table.isAppendOnly())
+	assert.Empty(t, table.SystemColumns)
+}
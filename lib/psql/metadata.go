@@ -0,0 +1,380 @@
+package psql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"majipoor/lib/binlog"
+	"time"
+)
+
+// majipoor keeps its own bookkeeping (snapshots, replication position) in a metadata schema,
+// separate from the replicated tables.
+
+type SnapshotRecord struct {
+	ID             int64          `db:"id"`
+	GtidSet        string         `db:"gtid_set"`
+	BinlogFile     string         `db:"binlog_file"`
+	BinlogPosition uint32         `db:"binlog_position"`
+	Tables         pq.StringArray `db:"tables"`
+	CreatedAt      time.Time      `db:"created_at"`
+}
+
+// Checkpoint is the position of the last change applied by the binlog applier.
+// It is updated in the same transaction as the applied changes.
+type Checkpoint struct {
+	Name           string `db:"name"`
+	GtidSet        string `db:"gtid_set"`
+	BinlogFile     string `db:"binlog_file"`
+	BinlogPosition uint32 `db:"binlog_position"`
+	// UpsertGtidSet, UpsertBinlogFile and UpsertBinlogPosition are set after a resumed
+	// snapshot: the chunks loaded after the interruption already hold the changes up to this
+	// position, which have to be applied as upserts. See UpsertUntil.
+	UpsertGtidSet        string    `db:"upsert_gtid_set"`
+	UpsertBinlogFile     string    `db:"upsert_binlog_file"`
+	UpsertBinlogPosition uint32    `db:"upsert_binlog_position"`
+	UpdatedAt            time.Time `db:"updated_at"`
+}
+
+// Position returns the position of the checkpoint.
+func (c *Checkpoint) Position() binlog.Position {
+	return binlog.Position{GTIDSet: c.GtidSet, File: c.BinlogFile, Pos: c.BinlogPosition}
+}
+
+// UpsertUntil returns the position up to which changes are applied as upserts, or nil if
+// they don't have to be.
+func (c *Checkpoint) UpsertUntil() *binlog.Position {
+	if c.UpsertGtidSet == "" && c.UpsertBinlogFile == "" {
+		return nil
+	}
+	return &binlog.Position{GTIDSet: c.UpsertGtidSet, File: c.UpsertBinlogFile, Pos: c.UpsertBinlogPosition}
+}
+
+const DefaultCheckpointName = "default"
+
+// ReplicationStatus is the lag of the binlog applier, updated with every applied batch.
+//
+// Lag is the time between the binlog event of the last applied transaction and its apply,
+// HeartbeatLag the same for the last heartbeat (see mysql.WriteHeartbeat), which is only
+// written when heartbeats are enabled, and keeps the lag measurable on idle databases.
+// Times are compared across the mysql and postgresql clocks.
+type ReplicationStatus struct {
+	Name          string     `db:"name"`
+	EventTime     *time.Time `db:"event_time"`
+	HeartbeatTime *time.Time `db:"heartbeat_time"`
+	UpdatedAt     time.Time  `db:"updated_at"`
+}
+
+// SnapshotJob is a snapshot into Schema that is in progress. Its chunks are recorded when
+// they are loaded, so that an interrupted snapshot can be resumed.
+type SnapshotJob struct {
+	Schema         string         `db:"schema_name"`
+	GtidSet        string         `db:"gtid_set"`
+	BinlogFile     string         `db:"binlog_file"`
+	BinlogPosition uint32         `db:"binlog_position"`
+	Tables         pq.StringArray `db:"tables"`
+	StartedAt      time.Time      `db:"started_at"`
+}
+
+// SnapshotChunk is a key range of a table of a snapshot job (see mysql.Chunk).
+// A NULL bound is open.
+type SnapshotChunk struct {
+	Schema     string         `db:"schema_name"`
+	Table      string         `db:"table_name"`
+	Chunk      int            `db:"chunk"`
+	Key        pq.StringArray `db:"key_columns"`
+	LowerBound pq.StringArray `db:"lower_bound"`
+	UpperBound pq.StringArray `db:"upper_bound"`
+	Done       bool           `db:"done"`
+	Rows       int64          `db:"rows"`
+	Duration   time.Duration  `db:"duration"`
+}
+
+// SchemaVersionRecord is the columns a mysql table has from a binlog position on
+// (see binlog.SchemaHistory). Columns is the JSON of a []*mysql.ColumnMetadata.
+type SchemaVersionRecord struct {
+	ID             int64     `db:"id"`
+	Database       string    `db:"database_name"`
+	Table          string    `db:"table_name"`
+	GtidSet        string    `db:"gtid_set"`
+	BinlogFile     string    `db:"binlog_file"`
+	BinlogPosition uint32    `db:"binlog_position"`
+	Columns        []byte    `db:"columns"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+func getMetadataTableStatements(schema string) []string {
+	return []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdentifier(schema)),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id bigserial PRIMARY KEY,
+	gtid_set text NOT NULL,
+	binlog_file text NOT NULL,
+	binlog_position bigint NOT NULL,
+	tables text[] NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+)`, QuoteTableName(schema, "snapshots")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name text PRIMARY KEY,
+	gtid_set text NOT NULL,
+	binlog_file text NOT NULL,
+	binlog_position bigint NOT NULL,
+	upsert_gtid_set text NOT NULL DEFAULT '',
+	upsert_binlog_file text NOT NULL DEFAULT '',
+	upsert_binlog_position bigint NOT NULL DEFAULT 0,
+	updated_at timestamptz NOT NULL DEFAULT now()
+)`, QuoteTableName(schema, "checkpoints")),
+		// checkpoints created before the upsert position
+		fmt.Sprintf(`ALTER TABLE %s
+	ADD COLUMN IF NOT EXISTS upsert_gtid_set text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS upsert_binlog_file text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS upsert_binlog_position bigint NOT NULL DEFAULT 0`, QuoteTableName(schema, "checkpoints")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	schema_name text PRIMARY KEY,
+	gtid_set text NOT NULL,
+	binlog_file text NOT NULL,
+	binlog_position bigint NOT NULL,
+	tables text[] NOT NULL,
+	started_at timestamptz NOT NULL DEFAULT now()
+)`, QuoteTableName(schema, "snapshot_jobs")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	schema_name text NOT NULL REFERENCES %s (schema_name) ON DELETE CASCADE,
+	table_name text NOT NULL,
+	chunk int NOT NULL,
+	key_columns text[] NOT NULL,
+	lower_bound text[],
+	upper_bound text[],
+	done boolean NOT NULL DEFAULT false,
+	rows bigint NOT NULL DEFAULT 0,
+	duration bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY (schema_name, table_name, chunk)
+)`, QuoteTableName(schema, "snapshot_chunks"), QuoteTableName(schema, "snapshot_jobs")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id bigserial PRIMARY KEY,
+	database_name text NOT NULL,
+	table_name text NOT NULL,
+	gtid_set text NOT NULL,
+	binlog_file text NOT NULL,
+	binlog_position bigint NOT NULL,
+	columns jsonb NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+)`, QuoteTableName(schema, "schema_versions")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name text PRIMARY KEY,
+	event_time timestamptz,
+	lag interval,
+	heartbeat_time timestamptz,
+	heartbeat_lag interval,
+	updated_at timestamptz NOT NULL DEFAULT now()
+)`, QuoteTableName(schema, "replication_status")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name text NOT NULL,
+	table_name text NOT NULL,
+	gtid_set text NOT NULL,
+	binlog_file text NOT NULL,
+	binlog_position bigint NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (name, table_name)
+)`, QuoteTableName(schema, "table_positions")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS schema_versions_table_idx ON %s (database_name, table_name)",
+			QuoteTableName(schema, "schema_versions")),
+	}
+}
+
+// CreateMetadataTables creates the metadata schema and its tables if they don't exist yet.
+func (pd *PsqlDB) CreateMetadataTables(schema string) error {
+	for _, sql_ := range getMetadataTableStatements(schema) {
+		_, err := pd.Db.Exec(sql_)
+		if err != nil {
+			return errors.Wrapf(err, "Could not create metadata tables in %s", schema)
+		}
+	}
+	return nil
+}
+
+// RecordSnapshot records a finished snapshot. It takes an Execer so that it can be
+// recorded in the same transaction as the swap of the snapshotted tables.
+func RecordSnapshot(tx sqlx.Execer, schema string, record *SnapshotRecord) error {
+	_, err := tx.Exec(fmt.Sprintf(
+		"INSERT INTO %s (gtid_set, binlog_file, binlog_position, tables) VALUES ($1, $2, $3, $4)",
+		QuoteTableName(schema, "snapshots")),
+		record.GtidSet, record.BinlogFile, record.BinlogPosition, record.Tables)
+	if err != nil {
+		return errors.Wrap(err, "Could not record snapshot")
+	}
+	return nil
+}
+
+// GetLastSnapshot returns the most recent snapshot, or nil if there is none.
+func (pd *PsqlDB) GetLastSnapshot(schema string) (*SnapshotRecord, error) {
+	record := &SnapshotRecord{}
+	err := pd.Db.Get(record, fmt.Sprintf(
+		"SELECT id, gtid_set, binlog_file, binlog_position, tables, created_at FROM %s ORDER BY id DESC LIMIT 1",
+		QuoteTableName(schema, "snapshots")))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get last snapshot")
+	}
+	return record, nil
+}
+
+// SaveCheckpoint records checkpoint. It takes an Execer so that it can be saved in the same
+// transaction as the changes it covers.
+func SaveCheckpoint(tx sqlx.Execer, schema string, checkpoint *Checkpoint) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (name, gtid_set, binlog_file, binlog_position,
+	upsert_gtid_set, upsert_binlog_file, upsert_binlog_position, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+ON CONFLICT (name) DO UPDATE SET
+	gtid_set = EXCLUDED.gtid_set,
+	binlog_file = EXCLUDED.binlog_file,
+	binlog_position = EXCLUDED.binlog_position,
+	upsert_gtid_set = EXCLUDED.upsert_gtid_set,
+	upsert_binlog_file = EXCLUDED.upsert_binlog_file,
+	upsert_binlog_position = EXCLUDED.upsert_binlog_position,
+	updated_at = EXCLUDED.updated_at`, QuoteTableName(schema, "checkpoints")),
+		checkpoint.Name, checkpoint.GtidSet, checkpoint.BinlogFile, checkpoint.BinlogPosition,
+		checkpoint.UpsertGtidSet, checkpoint.UpsertBinlogFile, checkpoint.UpsertBinlogPosition)
+	if err != nil {
+		return errors.Wrap(err, "Could not save checkpoint")
+	}
+	return nil
+}
+
+// SaveReplicationStatus records the lag of the changes applied in tx. The times that are nil
+// keep their previous value.
+func SaveReplicationStatus(tx sqlx.Execer, schema string, status *ReplicationStatus) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s AS s (name, event_time, lag, heartbeat_time, heartbeat_lag, updated_at)
+VALUES ($1, $2::timestamptz, now() - $2::timestamptz, $3::timestamptz, now() - $3::timestamptz, now())
+ON CONFLICT (name) DO UPDATE SET
+	event_time = COALESCE(EXCLUDED.event_time, s.event_time),
+	lag = COALESCE(EXCLUDED.lag, s.lag),
+	heartbeat_time = COALESCE(EXCLUDED.heartbeat_time, s.heartbeat_time),
+	heartbeat_lag = COALESCE(EXCLUDED.heartbeat_lag, s.heartbeat_lag),
+	updated_at = EXCLUDED.updated_at`, QuoteTableName(schema, "replication_status")),
+		status.Name, status.EventTime, status.HeartbeatTime)
+	if err != nil {
+		return errors.Wrap(err, "Could not save replication status")
+	}
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint called name, or nil if there is none.
+func (pd *PsqlDB) GetCheckpoint(schema string, name string) (*Checkpoint, error) {
+	return getCheckpoint(pd.Db, schema, name)
+}
+
+func getCheckpoint(q sqlx.Queryer, schema string, name string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	err := sqlx.Get(q, checkpoint, fmt.Sprintf(
+		`SELECT name, gtid_set, binlog_file, binlog_position,
+	upsert_gtid_set, upsert_binlog_file, upsert_binlog_position, updated_at
+FROM %s WHERE name = $1`,
+		QuoteTableName(schema, "checkpoints")), name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get checkpoint")
+	}
+	return checkpoint, nil
+}
+
+// GetSnapshotJob returns the snapshot job in progress into schema, or nil if there is none.
+func (pd *PsqlDB) GetSnapshotJob(metadataSchema string, schema string) (*SnapshotJob, error) {
+	job := &SnapshotJob{}
+	err := pd.Db.Get(job, fmt.Sprintf(
+		"SELECT schema_name, gtid_set, binlog_file, binlog_position, tables, started_at FROM %s WHERE schema_name = $1",
+		QuoteTableName(metadataSchema, "snapshot_jobs")), schema)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get snapshot job")
+	}
+	return job, nil
+}
+
+// SaveSnapshotJob records a new snapshot job along with its chunks.
+func SaveSnapshotJob(tx sqlx.Execer, metadataSchema string, job *SnapshotJob, chunks []*SnapshotChunk) error {
+	_, err := tx.Exec(fmt.Sprintf(
+		"INSERT INTO %s (schema_name, gtid_set, binlog_file, binlog_position, tables, started_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		QuoteTableName(metadataSchema, "snapshot_jobs")),
+		job.Schema, job.GtidSet, job.BinlogFile, job.BinlogPosition, job.Tables, job.StartedAt)
+	if err != nil {
+		return errors.Wrap(err, "Could not save snapshot job")
+	}
+	for _, c := range chunks {
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (schema_name, table_name, chunk, key_columns, lower_bound, upper_bound)
+VALUES ($1, $2, $3, $4, $5, $6)`, QuoteTableName(metadataSchema, "snapshot_chunks")),
+			job.Schema, c.Table, c.Chunk, c.Key, c.LowerBound, c.UpperBound)
+		if err != nil {
+			return errors.Wrapf(err, "Could not save chunk %d of %s", c.Chunk, c.Table)
+		}
+	}
+	return nil
+}
+
+// GetSnapshotChunks returns the chunks of the snapshot job into schema, in table and chunk order.
+func (pd *PsqlDB) GetSnapshotChunks(metadataSchema string, schema string) ([]*SnapshotChunk, error) {
+	var chunks []*SnapshotChunk
+	err := pd.Db.Select(&chunks, fmt.Sprintf(`SELECT schema_name, table_name, chunk, key_columns,
+	lower_bound, upper_bound, done, rows, duration
+FROM %s WHERE schema_name = $1 ORDER BY table_name, chunk`, QuoteTableName(metadataSchema, "snapshot_chunks")),
+		schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get snapshot chunks")
+	}
+	return chunks, nil
+}
+
+// CompleteSnapshotChunk marks chunk as loaded. It takes an Execer so that it can be
+// recorded in the same transaction as the rows of the chunk.
+func CompleteSnapshotChunk(tx sqlx.Execer, metadataSchema string, chunk *SnapshotChunk) error {
+	_, err := tx.Exec(fmt.Sprintf(
+		"UPDATE %s SET done = true, rows = $4, duration = $5 WHERE schema_name = $1 AND table_name = $2 AND chunk = $3",
+		QuoteTableName(metadataSchema, "snapshot_chunks")),
+		chunk.Schema, chunk.Table, chunk.Chunk, chunk.Rows, chunk.Duration)
+	if err != nil {
+		return errors.Wrapf(err, "Could not complete chunk %d of %s", chunk.Chunk, chunk.Table)
+	}
+	return nil
+}
+
+// DeleteSnapshotJob deletes the snapshot job into schema and its chunks, if any.
+func DeleteSnapshotJob(tx sqlx.Execer, metadataSchema string, schema string) error {
+	_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE schema_name = $1",
+		QuoteTableName(metadataSchema, "snapshot_jobs")), schema)
+	if err != nil {
+		return errors.Wrap(err, "Could not delete snapshot job")
+	}
+	return nil
+}
+
+// SaveSchemaVersion records a version of a table. It takes an Execer so that it can be saved
+// in the same transaction as the snapshot or the schema change.
+func SaveSchemaVersion(tx sqlx.Execer, schema string, version *SchemaVersionRecord) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (database_name, table_name, gtid_set, binlog_file, binlog_position, columns)
+VALUES ($1, $2, $3, $4, $5, $6)`, QuoteTableName(schema, "schema_versions")),
+		version.Database, version.Table, version.GtidSet, version.BinlogFile, version.BinlogPosition, string(version.Columns))
+	if err != nil {
+		return errors.Wrapf(err, "Could not save schema version of %s", version.Table)
+	}
+	return nil
+}
+
+// GetSchemaVersions returns the versions of a table, in the order they were recorded.
+func (pd *PsqlDB) GetSchemaVersions(schema string, database string, table string) ([]*SchemaVersionRecord, error) {
+	var versions []*SchemaVersionRecord
+	err := pd.Db.Select(&versions, fmt.Sprintf(`SELECT id, database_name, table_name, gtid_set, binlog_file,
+	binlog_position, columns, created_at
+FROM %s WHERE database_name = $1 AND table_name = $2 ORDER BY id`, QuoteTableName(schema, "schema_versions")),
+		database, table)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get schema versions of %s", table)
+	}
+	return versions, nil
+}
@@ -0,0 +1,77 @@
+package psql
+
+import (
+	"majipoor/lib/binlog"
+	"time"
+)
+
+// The replication metadata columns tell where the current content of a row comes from:
+// the operation (insert, update, delete or snapshot), the GTID and the commit time of the
+// mysql transaction, and when it was applied. Incremental models can filter on them.
+const (
+	OpColumn       = "_majipoor_op"
+	CommitTsColumn = "_majipoor_commit_ts"
+	SyncedAtColumn = "_majipoor_synced_at"
+)
+
+// SnapshotOperation is the operation of the snapshotted rows.
+const SnapshotOperation = "snapshot"
+
+// RowMetadata is the content of the metadata columns of the rows of a snapshot.
+type RowMetadata struct {
+	GtidSet    string
+	CommitTime time.Time
+}
+
+// applyMetadataColumns adds the metadata columns if they are enabled. Append-only tables
+// already have the GTID column.
+func (t *Table) applyMetadataColumns(settings MappingSettings) {
+	t.MetadataColumns = settings.MetadataColumns
+	if !t.MetadataColumns {
+		return
+	}
+	t.SystemColumns = append(t.SystemColumns, &Column{Name: OpColumn, Type: "text"})
+	if !t.isAppendOnly() {
+		t.SystemColumns = append(t.SystemColumns, &Column{Name: GtidColumn, Type: "text"})
+	}
+	t.SystemColumns = append(t.SystemColumns,
+		&Column{Name: CommitTsColumn, Type: "timestamptz"},
+		&Column{Name: SyncedAtColumn, Type: "timestamptz", NotNull: true, Default: stringPtr("now()")},
+	)
+}
+
+// metadataColumnNames returns the metadata columns that are set from the change, in the order
+// of metadataColumnValues. The sync time is the default, or set to now() by updates.
+func (t *Table) metadataColumnNames() []string {
+	if !t.MetadataColumns {
+		return nil
+	}
+	if t.isAppendOnly() {
+		return []string{OpColumn, CommitTsColumn}
+	}
+	return []string{OpColumn, GtidColumn, CommitTsColumn}
+}
+
+func (t *Table) metadataColumnValues(operation string, gtid string, commitTime time.Time) []interface{} {
+	if !t.MetadataColumns {
+		return nil
+	}
+	if t.isAppendOnly() {
+		return []interface{}{operation, commitTime}
+	}
+	return []interface{}{operation, gtid, commitTime}
+}
+
+func (t *Table) changeMetadataValues(change *binlog.RowChange) []interface{} {
+	return t.metadataColumnValues(string(change.Operation), change.GTID, change.Timestamp)
+}
+
+// snapshotMetadataValues returns the values of the metadata columns of snapshotted rows,
+// including the GTID column of append-only tables.
+func (t *Table) snapshotMetadataValues(metadata *RowMetadata) ([]string, []interface{}) {
+	if !t.MetadataColumns || metadata == nil {
+		return nil, nil
+	}
+	return []string{OpColumn, GtidColumn, CommitTsColumn},
+		[]interface{}{SnapshotOperation, metadata.GtidSet, metadata.CommitTime}
+}
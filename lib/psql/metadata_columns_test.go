@@ -0,0 +1,29 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func TestMapMetadataColumns(t *testing.T) {
+	columns := []*mysql.ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20)", ColumnKey: "PRI"},
+	}
+	table, err := MapTable("wp_users", columns, MappingSettings{MetadataColumns: true})
+	require.NoError(t, err)
+	var names []string
+	for _, c := range table.SystemColumns {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{OpColumn, GtidColumn, CommitTsColumn, SyncedAtColumn}, names)
+	assert.Equal(t, []string{OpColumn, GtidColumn, CommitTsColumn}, table.metadataColumnNames())
+	assert.Contains(t, table.onConflictClause(), `"_majipoor_synced_at" = now()`)
+
+	// append-only tables already have the GTID column
+	keyless := []*mysql.ColumnMetadata{{ColumnName: "v", DataType: "int", ColumnType: "int(11)"}}
+	table, err = MapTable("wp_log", keyless, MappingSettings{KeylessStrategy: KeylessAppendOnly, MetadataColumns: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{OpColumn, CommitTsColumn}, table.metadataColumnNames())
+}
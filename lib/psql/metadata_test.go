@@ -0,0 +1,18 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"majipoor/lib/binlog"
+	"testing"
+)
+
+func TestCheckpointUpsertUntil(t *testing.T) {
+	checkpoint := &Checkpoint{GtidSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"}
+	assert.Nil(t, checkpoint.UpsertUntil())
+
+	checkpoint.UpsertGtidSet = "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-9"
+	assert.Equal(t, &binlog.Position{GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-9"}, checkpoint.UpsertUntil())
+
+	checkpoint = &Checkpoint{UpsertBinlogFile: "mysql-bin.000042", UpsertBinlogPosition: 1234}
+	assert.Equal(t, &binlog.Position{File: "mysql-bin.000042", Pos: 1234}, checkpoint.UpsertUntil())
+}
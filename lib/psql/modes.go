@@ -0,0 +1,128 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// TableMode decides what the changes of a table keep of the past rows.
+type TableMode string
+
+const (
+	// TableModeMirror applies the changes as they are, the table mirrors the mysql table.
+	//
+	// This is the default.
+	TableModeMirror TableMode = "mirror"
+
+	// TableModeSoftDelete keeps deleted rows, with the time of their deletion in
+	// _majipoor_deleted_at, which is NULL for the rows that still exist in mysql.
+	TableModeSoftDelete TableMode = "soft-delete"
+
+	// TableModeHistory keeps every version of the rows (a type 2 slowly changing dimension).
+	// An update closes the current version by setting its _majipoor_valid_to, and inserts the
+	// new one, valid from the time of the change. A delete only closes the current version.
+	// The current versions are the rows whose _majipoor_valid_to is NULL.
+	//
+	// Binlog event times have a resolution of a second, so versions replaced within a second
+	// are valid from and to the same time. Snapshotted rows are valid from the snapshot, and
+	// snapshotting the table again starts a new history.
+	TableModeHistory TableMode = "history"
+)
+
+const (
+	DeletedAtColumn = "_majipoor_deleted_at"
+	ValidFromColumn = "_majipoor_valid_from"
+	ValidToColumn   = "_majipoor_valid_to"
+)
+
+func ParseTableMode(s string) (TableMode, error) {
+	switch TableMode(strings.ToLower(s)) {
+	case TableModeMirror:
+		return TableModeMirror, nil
+	case TableModeSoftDelete:
+		return TableModeSoftDelete, nil
+	case TableModeHistory:
+		return TableModeHistory, nil
+	default:
+		return "", errors.Errorf("Unknown table mode %s", s)
+	}
+}
+
+// ParseTableModes parses table=mode pairs.
+func ParseTableModes(pairs []string) (map[string]TableMode, error) {
+	modes := map[string]TableMode{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("Invalid table mode %s, expected table=mode", pair)
+		}
+		mode, err := ParseTableMode(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		modes[parts[0]] = mode
+	}
+	return modes, nil
+}
+
+// applyTableMode adds the system columns of the mode of the table. Append-only keyless tables
+// already keep every change, they are always mirrored.
+func (t *Table) applyTableMode(settings MappingSettings) {
+	t.Mode = settings.TableMode
+	if mode, ok := settings.TableModes[t.Name]; ok {
+		t.Mode = mode
+	}
+	if t.Mode == "" || t.isAppendOnly() {
+		t.Mode = TableModeMirror
+	}
+
+	switch t.Mode {
+	case TableModeSoftDelete:
+		t.SystemColumns = append(t.SystemColumns, &Column{Name: DeletedAtColumn, Type: "timestamptz"})
+	case TableModeHistory:
+		t.SystemColumns = append(t.SystemColumns,
+			&Column{Name: ValidFromColumn, Type: "timestamptz", NotNull: true, Default: stringPtr("now()")},
+			&Column{Name: ValidToColumn, Type: "timestamptz"},
+		)
+	case TableModeMirror:
+	}
+}
+
+func (t *Table) isSoftDelete() bool {
+	return t.Mode == TableModeSoftDelete
+}
+
+func (t *Table) isHistory() bool {
+	return t.Mode == TableModeHistory
+}
+
+// CanUpsert returns true if changes can be applied to the table as upserts, which makes
+// applying them again harmless. It needs a primary key, and history tables never upsert.
+func (t *Table) CanUpsert() bool {
+	return len(t.PrimaryKey) > 0 && !t.isHistory()
+}
+
+// currentCondition returns the condition matching the rows that exist in mysql, or "" if
+// the table only has those.
+func (t *Table) currentCondition() string {
+	switch t.Mode {
+	case TableModeSoftDelete:
+		return QuoteIdentifier(DeletedAtColumn) + " IS NULL"
+	case TableModeHistory:
+		return QuoteIdentifier(ValidToColumn) + " IS NULL"
+	}
+	return ""
+}
+
+// historyStatements returns the unique index of the current versions of a history table,
+// which has no primary key as it holds several versions of each row.
+func (t *Table) historyStatements(schema string) []string {
+	if len(t.PrimaryKey) == 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("CREATE UNIQUE INDEX ON %s (%s) WHERE %s", QuoteTableName(schema, t.Name),
+			strings.Join(quoteIdentifiers(t.PrimaryKey), ", "), t.currentCondition()),
+	}
+}
@@ -0,0 +1,38 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func TestMapTableModes(t *testing.T) {
+	columns := []*mysql.ColumnMetadata{
+		{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20)", ColumnKey: "PRI"},
+		{ColumnName: "status", DataType: "varchar", ColumnType: "varchar(20)", CharacterMaximumLength: intPtr(20)},
+	}
+	modes, err := ParseTableModes([]string{"wp_orders=history"})
+	require.NoError(t, err)
+	settings := MappingSettings{TableMode: TableModeSoftDelete, TableModes: modes}
+
+	table, err := MapTable("wp_users", columns, settings)
+	require.NoError(t, err)
+	assert.True(t, table.isSoftDelete())
+	assert.True(t, table.CanUpsert())
+	require.Len(t, table.SystemColumns, 1)
+	assert.Equal(t, DeletedAtColumn, table.SystemColumns[0].Name)
+	assert.Contains(t, table.onConflictClause(), `"_majipoor_deleted_at" = NULL`)
+
+	table, err = MapTable("wp_orders", columns, settings)
+	require.NoError(t, err)
+	assert.True(t, table.isHistory())
+	assert.False(t, table.CanUpsert())
+	statements := table.CreateTableStatements("majipoor")
+	require.Len(t, statements, 2)
+	assert.NotContains(t, statements[0], "PRIMARY KEY")
+	assert.Equal(t, `CREATE UNIQUE INDEX ON "majipoor"."wp_orders" ("id") WHERE "_majipoor_valid_to" IS NULL`, statements[1])
+
+	_, err = ParseTableModes([]string{"wp_orders"})
+	assert.Error(t, err)
+}
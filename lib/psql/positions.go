@@ -0,0 +1,242 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"majipoor/lib/binlog"
+	"time"
+)
+
+// The tables of a schema aren't always at the position of the checkpoint: a snapshot of some
+// of the tables loads them at its own position. A table position is the position a table
+// holds the changes up to, when it is ahead of the checkpoint. The applier skips the changes
+// of the table until the checkpoint passes it, and then forgets it.
+//
+// Table positions are recorded under the name of their checkpoint, and under the name of a
+// generation schema for the tables swapped into it, so that they can be rolled back.
+
+// TablePosition is the position a table holds the changes up to.
+type TablePosition struct {
+	Name           string    `db:"name"`
+	Table          string    `db:"table_name"`
+	GtidSet        string    `db:"gtid_set"`
+	BinlogFile     string    `db:"binlog_file"`
+	BinlogPosition uint32    `db:"binlog_position"`
+	UpdatedAt      time.Time `db:"updated_at"`
+}
+
+// SaveTablePosition records the position of table under name.
+func SaveTablePosition(tx sqlx.Execer, schema string, name string, table string, position binlog.Position) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (name, table_name, gtid_set, binlog_file, binlog_position, updated_at)
+VALUES ($1, $2, $3, $4, $5, now())
+ON CONFLICT (name, table_name) DO UPDATE SET
+	gtid_set = EXCLUDED.gtid_set,
+	binlog_file = EXCLUDED.binlog_file,
+	binlog_position = EXCLUDED.binlog_position,
+	updated_at = EXCLUDED.updated_at`, QuoteTableName(schema, "table_positions")),
+		name, table, position.GTIDSet, position.File, position.Pos)
+	if err != nil {
+		return errors.Wrapf(err, "Could not save position of %s", table)
+	}
+	return nil
+}
+
+// GetTablePositions returns the positions recorded under name, by table.
+func (pd *PsqlDB) GetTablePositions(schema string, name string) (map[string]binlog.Position, error) {
+	return getTablePositions(pd.Db, schema, name)
+}
+
+func getTablePositions(q sqlx.Queryer, schema string, name string) (map[string]binlog.Position, error) {
+	var records []*TablePosition
+	err := sqlx.Select(q, &records, fmt.Sprintf(
+		"SELECT name, table_name, gtid_set, binlog_file, binlog_position, updated_at FROM %s WHERE name = $1",
+		QuoteTableName(schema, "table_positions")), name)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get table positions")
+	}
+	positions := map[string]binlog.Position{}
+	for _, r := range records {
+		positions[r.Table] = binlog.Position{GTIDSet: r.GtidSet, File: r.BinlogFile, Pos: r.BinlogPosition}
+	}
+	return positions, nil
+}
+
+// DeleteTablePositions deletes the positions of tables recorded under name, all of them if
+// tables is nil.
+func DeleteTablePositions(tx sqlx.Execer, schema string, name string, tables []string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE name = $1", QuoteTableName(schema, "table_positions"))
+	args := []interface{}{name}
+	if tables != nil {
+		query += " AND table_name = ANY($2)"
+		args = append(args, pq.Array(tables))
+	}
+	_, err := tx.Exec(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "Could not delete table positions")
+	}
+	return nil
+}
+
+// RecordTablesPosition records that tables of schema hold the changes up to position, after
+// they have been loaded, in the default checkpoint and the table positions.
+//
+// The checkpoint is reset to position if tables are all the tables of schema, or if there is
+// no checkpoint yet. Otherwise the other tables keep their position: if the checkpoint is at
+// or before position, tables get position as table position, and if it is after position, the
+// checkpoint moves back to position and the other tables get its previous position.
+//
+// Inserts are applied as upserts up to upsertUntil if it is set, see Checkpoint.UpsertUntil.
+// The applier must not run meanwhile, it would overwrite the checkpoint.
+func RecordTablesPosition(tx *sqlx.Tx, metadataSchema string, schema string, tables []string,
+	position binlog.Position, upsertUntil *binlog.Position) error {
+	checkpoint, err := getCheckpoint(tx, metadataSchema, DefaultCheckpointName)
+	if err != nil {
+		return err
+	}
+	var schemaTables []string
+	err = tx.Select(&schemaTables,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_type = 'BASE TABLE'",
+		schema)
+	if err != nil {
+		return errors.Wrapf(err, "Could not get tables of %s", schema)
+	}
+	loaded := map[string]bool{}
+	for _, t := range tables {
+		loaded[t] = true
+	}
+	var others []string
+	for _, t := range schemaTables {
+		if !loaded[t] {
+			others = append(others, t)
+		}
+	}
+
+	if checkpoint == nil || len(others) == 0 {
+		checkpoint = &Checkpoint{Name: DefaultCheckpointName}
+		setPosition(checkpoint, position)
+		setUpsertUntil(checkpoint, upsertUntil)
+		if len(others) == 0 {
+			// tables is every table
+			tables = nil
+		}
+		if err = DeleteTablePositions(tx, metadataSchema, DefaultCheckpointName, tables); err != nil {
+			return err
+		}
+		return SaveCheckpoint(tx, metadataSchema, checkpoint)
+	}
+
+	current := checkpoint.Position()
+	behind, err := position.Includes(current)
+	if err != nil {
+		return err
+	}
+	if behind {
+		same, err := current.Includes(position)
+		if err != nil {
+			return err
+		}
+		if same {
+			err = DeleteTablePositions(tx, metadataSchema, DefaultCheckpointName, tables)
+		} else {
+			for _, t := range tables {
+				if err = SaveTablePosition(tx, metadataSchema, DefaultCheckpointName, t, position); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		// the applier has gone past position, the checkpoint moves back for tables
+		positions, err := getTablePositions(tx, metadataSchema, DefaultCheckpointName)
+		if err != nil {
+			return err
+		}
+		for _, t := range others {
+			if p, ok := positions[t]; ok {
+				ahead, err := p.Includes(current)
+				if err != nil {
+					return err
+				}
+				if ahead {
+					continue
+				}
+			}
+			if err = SaveTablePosition(tx, metadataSchema, DefaultCheckpointName, t, current); err != nil {
+				return err
+			}
+		}
+		if err = DeleteTablePositions(tx, metadataSchema, DefaultCheckpointName, tables); err != nil {
+			return err
+		}
+		setPosition(checkpoint, position)
+	}
+
+	if upsertUntil != nil {
+		if previous := checkpoint.UpsertUntil(); previous != nil {
+			later, err := upsertUntil.Includes(*previous)
+			if err != nil {
+				return err
+			}
+			if !later {
+				upsertUntil = previous
+			}
+		}
+		setUpsertUntil(checkpoint, upsertUntil)
+	}
+	return SaveCheckpoint(tx, metadataSchema, checkpoint)
+}
+
+// RecordGenerationPositions records the positions of tables of the default checkpoint under
+// the name of generation, which they are moved into. The tables whose position is unknown are
+// left out.
+func RecordGenerationPositions(tx *sqlx.Tx, metadataSchema string, generation string, tables []string) error {
+	checkpoint, err := getCheckpoint(tx, metadataSchema, DefaultCheckpointName)
+	if err != nil {
+		return err
+	}
+	positions, err := getTablePositions(tx, metadataSchema, DefaultCheckpointName)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		p, ok := positions[t]
+		if checkpoint != nil {
+			current := checkpoint.Position()
+			ahead := false
+			if ok {
+				if ahead, err = p.Includes(current); err != nil {
+					return err
+				}
+			}
+			if !ahead {
+				p, ok = current, true
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err = SaveTablePosition(tx, metadataSchema, generation, t, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setPosition(checkpoint *Checkpoint, position binlog.Position) {
+	checkpoint.GtidSet = position.GTIDSet
+	checkpoint.BinlogFile = position.File
+	checkpoint.BinlogPosition = position.Pos
+}
+
+func setUpsertUntil(checkpoint *Checkpoint, position *binlog.Position) {
+	checkpoint.UpsertGtidSet, checkpoint.UpsertBinlogFile, checkpoint.UpsertBinlogPosition = "", "", 0
+	if position != nil {
+		checkpoint.UpsertGtidSet = position.GTIDSet
+		checkpoint.UpsertBinlogFile = position.File
+		checkpoint.UpsertBinlogPosition = position.Pos
+	}
+}
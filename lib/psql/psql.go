@@ -0,0 +1,33 @@
+package psql
+
+import (
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"time"
+)
+
+type PsqlDB struct {
+	Db *sqlx.DB
+}
+
+func (pd *PsqlDB) Close() error {
+	return pd.Db.Close()
+}
+
+func NewPsqlDB(connectionString string) (*PsqlDB, error) {
+	db, err := sqlx.Connect("postgres", connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetConnMaxLifetime(time.Minute * 3)
+	db.SetMaxIdleConns(10)
+	db.SetMaxOpenConns(10)
+
+	return &PsqlDB{Db: db}, nil
+}
+
+func (pd *PsqlDB) Exec(sql string, args ...any) (sql.Result, error) {
+	return pd.Db.Exec(sql, args...)
+}
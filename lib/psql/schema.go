@@ -0,0 +1,242 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"majipoor/lib/mysql"
+	"strings"
+)
+
+// Table is a postgresql table mapped from a mysql table.
+type Table struct {
+	Name string
+	// Columns are mapped from the mysql columns, in the same order
+	Columns    []*Column
+	PrimaryKey []string
+	// KeylessStrategy is how changes are applied if the table has no primary key
+	KeylessStrategy KeylessStrategy
+	// Mode is what the table keeps of the past rows
+	Mode TableMode
+	// MetadataColumns is true if the table has the replication metadata columns
+	MetadataColumns bool
+	// SystemColumns are added by majipoor after the mapped columns. They are never loaded
+	// from mysql, and have a default or are filled in by the applier.
+	SystemColumns []*Column
+	// Indexes are the secondary indexes, see MapIndexes
+	Indexes []*Index
+	// ForeignKeys and Checks are the mysql constraints, see MapConstraints
+	ForeignKeys []*ForeignKey
+	Checks      []*Check
+
+	metadataSchema string
+	// database is the mysql database of the table
+	database string
+}
+
+func MapTable(name string, columns []*mysql.ColumnMetadata, settings MappingSettings) (*Table, error) {
+	t := &Table{Name: name}
+	for _, c := range columns {
+		column, err := MapColumn(c, settings.TypeMappingMode)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not map table %s", name)
+		}
+		t.Columns = append(t.Columns, column)
+		if c.ColumnKey == "PRI" {
+			t.PrimaryKey = append(t.PrimaryKey, c.ColumnName)
+		}
+	}
+	if len(t.PrimaryKey) == 0 {
+		t.applyKeylessStrategy(settings)
+	}
+	t.applyTableMode(settings)
+	t.applyMetadataColumns(settings)
+
+	return t, nil
+}
+
+// AllColumns returns the mapped columns followed by the system columns.
+func (t *Table) AllColumns() []*Column {
+	var columns []*Column
+	columns = append(columns, t.Columns...)
+	return append(columns, t.SystemColumns...)
+}
+
+func (c *Column) ColumnDefinition() string {
+	s := fmt.Sprintf("%s %s", QuoteIdentifier(c.Name), c.Type)
+	if c.NotNull {
+		s += " NOT NULL"
+	}
+	if c.Default != nil {
+		s += " DEFAULT " + *c.Default
+	}
+	if c.Check != nil {
+		s += fmt.Sprintf(" CHECK (%s)", *c.Check)
+	}
+	return s
+}
+
+func QuoteTableName(schema string, table string) string {
+	return QuoteIdentifier(schema) + "." + QuoteIdentifier(table)
+}
+
+func quoteIdentifiers(names []string) []string {
+	var res []string
+	for _, name := range names {
+		res = append(res, QuoteIdentifier(name))
+	}
+	return res
+}
+
+func (t *Table) CreateTableStatement(schema string) string {
+	var lines []string
+	for _, c := range t.AllColumns() {
+		lines = append(lines, "\t"+c.ColumnDefinition())
+	}
+	// the versions of a history table share their primary key
+	if len(t.PrimaryKey) > 0 && !t.isHistory() {
+		lines = append(lines, fmt.Sprintf("\tPRIMARY KEY (%s)", strings.Join(quoteIdentifiers(t.PrimaryKey), ", ")))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n)", QuoteTableName(schema, t.Name), strings.Join(lines, ",\n"))
+}
+
+// CreateTableStatements returns the CREATE TABLE statement of the table, followed by the
+// statements creating what it needs (the row hash trigger of a keyless table, the index of
+// the current versions of a history table).
+func (t *Table) CreateTableStatements(schema string) []string {
+	statements := []string{t.CreateTableStatement(schema)}
+	if t.hasRowHash() {
+		statements = append(statements, t.rowHashStatements(schema)...)
+	}
+	if t.isHistory() {
+		statements = append(statements, t.historyStatements(schema)...)
+	}
+	return statements
+}
+
+func (t *Table) DropTableStatement(schema string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", QuoteTableName(schema, t.Name))
+}
+
+func (pd *PsqlDB) TableExists(schema string, table string) (bool, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("table_name").From("information_schema.tables")
+	sb.Where(sb.Equal("table_schema", schema), sb.Equal("table_name", table))
+	sql_, args_ := sb.Build()
+
+	var exists bool
+	err := pd.Db.QueryRow(fmt.Sprintf("SELECT EXISTS(%s)", sql_), args_...).Scan(&exists)
+	return exists, err
+}
+
+type CreateSchemaSettings struct {
+	Force  bool
+	Schema string
+	Tables []*Table
+	// DeferIndexes leaves out the indexes, to create them once the tables are loaded
+	DeferIndexes bool
+	// Constraints is what becomes of the mysql constraints of the tables
+	Constraints ConstraintMode
+	// ConstraintsOnly only adds the constraints to the existing tables
+	ConstraintsOnly bool
+}
+
+// GetCreateSchemaStatements returns the statements creating the schema and its tables,
+// and then their indexes and constraints. If force is set, existing tables are dropped first.
+func GetCreateSchemaStatements(settings CreateSchemaSettings) []string {
+	if settings.ConstraintsOnly {
+		return getConstraintStatements(settings)
+	}
+	statements := []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdentifier(settings.Schema)),
+	}
+	functionSchemas := map[string]bool{}
+	for _, t := range settings.Tables {
+		if t.hasRowHash() && !functionSchemas[t.metadataSchema] {
+			functionSchemas[t.metadataSchema] = true
+			statements = append(statements, getRowHashFunctionStatements(t.metadataSchema)...)
+		}
+	}
+	for _, t := range settings.Tables {
+		if settings.Force {
+			statements = append(statements, t.DropTableStatement(settings.Schema))
+		}
+		statements = append(statements, t.CreateTableStatements(settings.Schema)...)
+	}
+	if !settings.DeferIndexes {
+		for _, t := range settings.Tables {
+			statements = append(statements, t.CreateIndexStatements(settings.Schema)...)
+		}
+	}
+	return append(statements, getConstraintStatements(settings)...)
+}
+
+func getConstraintStatements(settings CreateSchemaSettings) []string {
+	tables := map[string]*Table{}
+	for _, t := range settings.Tables {
+		tables[t.Name] = t
+	}
+	var statements []string
+	for _, t := range settings.Tables {
+		statements = append(statements, t.constraintStatements(settings.Schema, settings.Constraints, tables)...)
+	}
+	return statements
+}
+
+// CreateSchema creates the schema and its tables in a single transaction.
+func (pd *PsqlDB) CreateSchema(settings CreateSchemaSettings) error {
+	if !settings.Force && !settings.ConstraintsOnly {
+		for _, t := range settings.Tables {
+			exists, err := pd.TableExists(settings.Schema, t.Name)
+			if err != nil {
+				return errors.Wrap(err, "Could not check if table exists")
+			}
+			if exists {
+				return errors.Errorf("Table %s.%s already exists", settings.Schema, t.Name)
+			}
+		}
+	}
+
+	tx, err := pd.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, sql_ := range GetCreateSchemaStatements(settings) {
+		log.Debug().Str("sql", sql_).Msg("Executing statement")
+		_, err = tx.Exec(sql_)
+		if err != nil {
+			return errors.Wrapf(err, "Could not execute %s", sql_)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Could not commit schema creation")
+	}
+	log.Info().Str("schema", settings.Schema).Int("tables", len(settings.Tables)).Msg("Created schema")
+
+	return nil
+}
+
+// CreateIndexes creates the indexes of the tables that don't exist yet.
+func (pd *PsqlDB) CreateIndexes(schema string, tables []*Table) error {
+	for _, t := range tables {
+		for _, sql_ := range t.CreateIndexStatements(schema) {
+			log.Debug().Str("sql", sql_).Msg("Executing statement")
+			_, err := pd.Db.Exec(sql_)
+			if err != nil {
+				return errors.Wrapf(err, "Could not execute %s", sql_)
+			}
+		}
+		if len(t.Indexes) > 0 {
+			log.Info().Str("table", t.Name).Int("indexes", len(t.Indexes)).Msg("Created indexes")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A full reload is loaded into a staging schema, and then swapped table by table into the
+// destination schema. The tables it replaces are moved into a generation schema called
+// <schema>__gen_<timestamp>, which is kept around so that the swap can be rolled back. The
+// positions of its tables are recorded under its name, see RecordGenerationPositions.
+
+const generationSeparator = "__gen_"
+const generationTimeFormat = "20060102150405"
+
+func GetStagingSchemaName(schema string) string {
+	return schema + "__staging"
+}
+
+func GetGenerationSchemaName(schema string, t time.Time) string {
+	return schema + generationSeparator + t.UTC().Format(generationTimeFormat)
+}
+
+type Generation struct {
+	Schema    string
+	CreatedAt time.Time
+}
+
+// RecreateSchema drops schema and everything in it, and creates it again empty.
+func (pd *PsqlDB) RecreateSchema(schema string) error {
+	_, err := pd.Db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", QuoteIdentifier(schema)))
+	if err != nil {
+		return errors.Wrapf(err, "Could not drop schema %s", schema)
+	}
+	_, err = pd.Db.Exec(fmt.Sprintf("CREATE SCHEMA %s", QuoteIdentifier(schema)))
+	if err != nil {
+		return errors.Wrapf(err, "Could not create schema %s", schema)
+	}
+	return nil
+}
+
+func (pd *PsqlDB) GetSchemaTables(schema string) ([]string, error) {
+	var tables []string
+	err := pd.Db.Select(&tables,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_type = 'BASE TABLE'",
+		schema)
+	return tables, err
+}
+
+// SwapTables moves tables from fromSchema into toSchema in a single transaction.
+// See SwapTablesTx.
+func (pd *PsqlDB) SwapTables(fromSchema string, toSchema string, backupSchema string, tables []string) error {
+	tx, err := pd.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = SwapTablesTx(tx, fromSchema, toSchema, backupSchema, tables)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Could not commit swap")
+	}
+	log.Info().Str("from", fromSchema).Str("to", toSchema).Str("backup", backupSchema).
+		Int("tables", len(tables)).Msg("Swapped tables")
+
+	return nil
+}
+
+// CheckSwappable returns an error if tables of schema can't be swapped. Foreign keys and views
+// are bound to tables, not to their names: the foreign keys and views of the other tables would
+// follow the replaced tables into the generation schema, and be dropped with it. Drop them
+// before swapping, and create them again afterwards (foreign keys with create-schema
+// --constraints-only).
+func CheckSwappable(q sqlx.Queryer, schema string, tables []string) error {
+	var foreignKeys []string
+	err := sqlx.Select(q, &foreignKeys, `SELECT DISTINCT c.conrelid::regclass::text || '.' || c.conname
+FROM pg_constraint c
+JOIN pg_class t ON t.oid IN (c.conrelid, c.confrelid)
+JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE c.contype = 'f' AND n.nspname = $1 AND t.relname = ANY($2)`, schema, pq.Array(tables))
+	if err != nil {
+		return errors.Wrapf(err, "Could not get foreign keys of the tables of %s", schema)
+	}
+	if len(foreignKeys) > 0 {
+		return errors.Errorf("Tables of %s with foreign keys can't be swapped, drop the foreign keys %s first",
+			schema, strings.Join(foreignKeys, ", "))
+	}
+
+	var views []string
+	err = sqlx.Select(q, &views, `SELECT DISTINCT v.oid::regclass::text
+FROM pg_depend d
+JOIN pg_rewrite r ON r.oid = d.objid
+JOIN pg_class v ON v.oid = r.ev_class
+JOIN pg_class t ON t.oid = d.refobjid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE d.classid = 'pg_rewrite'::regclass AND d.refclassid = 'pg_class'::regclass
+AND v.oid <> t.oid AND n.nspname = $1 AND t.relname = ANY($2)`, schema, pq.Array(tables))
+	if err != nil {
+		return errors.Wrapf(err, "Could not get views of the tables of %s", schema)
+	}
+	if len(views) > 0 {
+		return errors.Errorf("Tables of %s with views can't be swapped, drop the views %s first",
+			schema, strings.Join(views, ", "))
+	}
+	return nil
+}
+
+// SwapTablesTx moves tables from fromSchema into toSchema as part of tx.
+// The tables they replace in toSchema are moved into backupSchema, which is created.
+// fromSchema is dropped once empty. See CheckSwappable.
+func SwapTablesTx(tx *sqlx.Tx, fromSchema string, toSchema string, backupSchema string, tables []string) error {
+	for _, schema := range []string{fromSchema, toSchema} {
+		if err := CheckSwappable(tx, schema, tables); err != nil {
+			return err
+		}
+	}
+
+	statements := []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdentifier(toSchema)),
+		fmt.Sprintf("CREATE SCHEMA %s", QuoteIdentifier(backupSchema)),
+	}
+	for _, table := range tables {
+		var exists bool
+		err := tx.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2)",
+			toSchema, table).Scan(&exists)
+		if err != nil {
+			return errors.Wrap(err, "Could not check if table exists")
+		}
+		if exists {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s",
+				QuoteTableName(toSchema, table), QuoteIdentifier(backupSchema)))
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s",
+			QuoteTableName(fromSchema, table), QuoteIdentifier(toSchema)))
+	}
+	statements = append(statements, fmt.Sprintf("DROP SCHEMA %s", QuoteIdentifier(fromSchema)))
+
+	for _, sql_ := range statements {
+		log.Debug().Str("sql", sql_).Msg("Executing statement")
+		_, err := tx.Exec(sql_)
+		if err != nil {
+			return errors.Wrapf(err, "Could not execute %s", sql_)
+		}
+	}
+
+	return nil
+}
+
+// GetGenerations returns the generation schemas of schema, most recent first.
+func (pd *PsqlDB) GetGenerations(schema string) ([]*Generation, error) {
+	var schemas []string
+	err := pd.Db.Select(&schemas, "SELECT schema_name FROM information_schema.schemata")
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not list schemas")
+	}
+
+	var generations []*Generation
+	prefix := schema + generationSeparator
+	for _, s := range schemas {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		t, err := time.Parse(generationTimeFormat, s[len(prefix):])
+		if err != nil {
+			log.Warn().Str("schema", s).Msg("Could not parse generation timestamp")
+			continue
+		}
+		generations = append(generations, &Generation{Schema: s, CreatedAt: t})
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].CreatedAt.After(generations[j].CreatedAt)
+	})
+
+	return generations, nil
+}
+
+// DropExpiredGenerations drops the generation schemas of schema older than keepFor, and their
+// positions in metadataSchema. Nothing is dropped in cascade: a generation that other objects
+// depend on is kept, with a warning.
+func (pd *PsqlDB) DropExpiredGenerations(schema string, metadataSchema string, keepFor time.Duration) error {
+	generations, err := pd.GetGenerations(schema)
+	if err != nil {
+		return err
+	}
+	for _, g := range generations {
+		if time.Since(g.CreatedAt) < keepFor {
+			continue
+		}
+		if err = pd.dropGeneration(g, metadataSchema); err != nil {
+			log.Warn().Err(err).Str("schema", g.Schema).Msg("Could not drop expired generation, keeping it")
+			continue
+		}
+		log.Info().Str("schema", g.Schema).Time("created-at", g.CreatedAt).Msg("Dropped expired generation")
+	}
+	return nil
+}
+
+// dropGeneration drops the tables of a generation, its schema and its positions, in a single
+// transaction.
+func (pd *PsqlDB) dropGeneration(g *Generation, metadataSchema string) error {
+	tables, err := pd.GetSchemaTables(g.Schema)
+	if err != nil {
+		return errors.Wrapf(err, "Could not get tables of %s", g.Schema)
+	}
+
+	tx, err := pd.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var statements []string
+	if len(tables) > 0 {
+		var names []string
+		for _, table := range tables {
+			names = append(names, QuoteTableName(g.Schema, table))
+		}
+		statements = append(statements, "DROP TABLE "+strings.Join(names, ", "))
+	}
+	statements = append(statements, fmt.Sprintf("DROP SCHEMA %s", QuoteIdentifier(g.Schema)))
+	for _, sql_ := range statements {
+		log.Debug().Str("sql", sql_).Msg("Executing statement")
+		_, err = tx.Exec(sql_)
+		if err != nil {
+			return errors.Wrapf(err, "Could not execute %s", sql_)
+		}
+	}
+	if err = DeleteTablePositions(tx, metadataSchema, g.Schema, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RollbackGeneration swaps the most recent generation of schema back into schema, and the
+// positions of its tables back into the default checkpoint of metadataSchema (see
+// RecordTablesPosition). The tables it replaces are kept as a new generation.
+//
+// A generation without the positions of its tables can't be rolled back, the applier wouldn't
+// know which changes they miss.
+func (pd *PsqlDB) RollbackGeneration(schema string, metadataSchema string) error {
+	generations, err := pd.GetGenerations(schema)
+	if err != nil {
+		return err
+	}
+	if len(generations) == 0 {
+		return errors.Errorf("No generation to roll back to for schema %s", schema)
+	}
+	g := generations[0]
+
+	tables, err := pd.GetSchemaTables(g.Schema)
+	if err != nil {
+		return errors.Wrapf(err, "Could not get tables of %s", g.Schema)
+	}
+
+	positions, err := pd.GetTablePositions(metadataSchema, g.Schema)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, ok := positions[table]; !ok {
+			return errors.Errorf("The position of %s in %s is unknown, it can't be rolled back, snapshot the tables again instead",
+				table, g.Schema)
+		}
+	}
+
+	log.Info().Str("schema", schema).Str("generation", g.Schema).Msg("Rolling back")
+	tx, err := pd.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	backupSchema := GetGenerationSchemaName(schema, time.Now())
+	err = RecordGenerationPositions(tx, metadataSchema, backupSchema, tables)
+	if err != nil {
+		return err
+	}
+	err = SwapTablesTx(tx, g.Schema, schema, backupSchema, tables)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		err = RecordTablesPosition(tx, metadataSchema, schema, []string{table}, positions[table], nil)
+		if err != nil {
+			return err
+		}
+	}
+	if err = DeleteTablePositions(tx, metadataSchema, g.Schema, nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Could not commit rollback")
+	}
+	log.Info().Str("from", g.Schema).Str("to", schema).Str("backup", backupSchema).
+		Int("tables", len(tables)).Msg("Swapped tables")
+
+	return nil
+}
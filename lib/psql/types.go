@@ -0,0 +1,250 @@
+// Package psql contains the postgresql side of majipoor: mapping the mysql schema
+// to postgresql types, creating the destination schema and loading data into it.
+package psql
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"majipoor/lib/mysql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TypeMappingMode decides how closely the postgresql schema follows the mysql schema.
+type TypeMappingMode string
+
+const (
+	// TypeMappingLoose maps every mysql type to a postgresql type that can hold every value
+	// mysql lets through, even invalid ones. All columns are nullable (mysql zero dates
+	// are loaded as NULL), unsigned integers are widened, char/varchar lose their length,
+	// tinyint(1) stays an integer, TIME becomes an interval, enums and spatial types become
+	// text and unknown types fall back to text.
+	//
+	// This is the default, so that the laxity of mysql never breaks replication.
+	TypeMappingLoose TypeMappingMode = "loose"
+
+	// TypeMappingStrict maps to the closest postgresql type and keeps NOT NULL.
+	// tinyint(1) and bit(1) become boolean, enums get a CHECK constraint on their values,
+	// spatial types become PostGIS geometry and unknown types are an error.
+	//
+	// Rows that don't fit (zero dates in NOT NULL columns, a 2 in a tinyint(1), a TIME
+	// above 24 hours) will fail to load.
+	TypeMappingStrict TypeMappingMode = "strict"
+)
+
+func ParseTypeMappingMode(s string) (TypeMappingMode, error) {
+	switch TypeMappingMode(strings.ToLower(s)) {
+	case TypeMappingLoose:
+		return TypeMappingLoose, nil
+	case TypeMappingStrict:
+		return TypeMappingStrict, nil
+	default:
+		return "", errors.Errorf("Unknown type mapping mode %s", s)
+	}
+}
+
+// Column is a postgresql column mapped from a mysql column.
+type Column struct {
+	Name    string
+	Type    string
+	NotNull bool
+	// Check is an optional CHECK expression (strict mode only)
+	Check *string
+	// Default is an optional DEFAULT expression (system columns only)
+	Default *string
+	// Spatial is true for the mysql spatial types, whatever their postgresql type
+	Spatial bool
+}
+
+var spatialDatatypes = []string{
+	"point", "geometry", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon",
+	"geometrycollection",
+}
+
+var blobDatatypes = []string{
+	"blob", "tinyblob", "mediumblob", "longblob", "binary", "varbinary",
+}
+
+var textDatatypes = []string{
+	"text", "tinytext", "mediumtext", "longtext",
+}
+
+func contains(needle string, haystack []string) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
+
+var typeLengthRegexp = regexp.MustCompile(`^\w+\((\d+)\)`)
+
+// getTypeLength extracts the length from a column type like "varchar(255)" or "datetime(6)"
+func getTypeLength(columnType string) *int {
+	matches := typeLengthRegexp.FindStringSubmatch(columnType)
+	if matches == nil {
+		return nil
+	}
+	length, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return nil
+	}
+	return &length
+}
+
+// QuoteLiteral quotes a string literal (assuming standard_conforming_strings)
+func QuoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// MapColumn maps a mysql column to a postgresql column according to mode.
+func MapColumn(c *mysql.ColumnMetadata, mode TypeMappingMode) (*Column, error) {
+	strict := mode == TypeMappingStrict
+	res := &Column{
+		Name:    c.ColumnName,
+		NotNull: strict && c.IsNullable == "NO",
+	}
+
+	dataType := strings.ToLower(c.DataType)
+	columnType := strings.ToLower(c.ColumnType)
+	length := getTypeLength(columnType)
+
+	switch {
+	case dataType == "tinyint":
+		if strict && length != nil && *length == 1 {
+			res.Type = "boolean"
+		} else {
+			res.Type = "smallint"
+		}
+
+	case dataType == "smallint":
+		res.Type = "smallint"
+		if c.IsUnsigned() {
+			res.Type = "integer"
+		}
+
+	case dataType == "mediumint":
+		res.Type = "integer"
+
+	case dataType == "int" || dataType == "integer":
+		res.Type = "integer"
+		if c.IsUnsigned() {
+			res.Type = "bigint"
+		}
+
+	case dataType == "bigint":
+		res.Type = "bigint"
+		if c.IsUnsigned() {
+			res.Type = "numeric(20,0)"
+		}
+
+	case dataType == "decimal" || dataType == "numeric":
+		if c.NumericPrecision != nil && c.NumericScale != nil {
+			res.Type = fmt.Sprintf("numeric(%d,%d)", *c.NumericPrecision, *c.NumericScale)
+		} else {
+			res.Type = "numeric"
+		}
+
+	case dataType == "float":
+		res.Type = "real"
+
+	case dataType == "double" || dataType == "real":
+		res.Type = "double precision"
+
+	case dataType == "bit":
+		// bit values are loaded as integers (see mysql.GetSelectCSVSatement)
+		switch {
+		case length == nil || *length == 1:
+			if strict {
+				res.Type = "boolean"
+			} else {
+				res.Type = "smallint"
+			}
+		case *length < 64:
+			res.Type = "bigint"
+		default:
+			res.Type = "numeric(20,0)"
+		}
+
+	case dataType == "year":
+		res.Type = "smallint"
+
+	case dataType == "date":
+		res.Type = "date"
+
+	case dataType == "datetime" || dataType == "timestamp":
+		// mysql timestamps are returned in the session timezone, so we don't use timestamptz
+		if length != nil {
+			res.Type = fmt.Sprintf("timestamp(%d)", *length)
+		} else {
+			res.Type = "timestamp"
+		}
+
+	case dataType == "time":
+		// mysql TIME ranges from -838:59:59 to 838:59:59
+		if strict {
+			res.Type = "time"
+		} else {
+			res.Type = "interval"
+		}
+
+	case dataType == "char" || dataType == "varchar":
+		if strict && length != nil {
+			if dataType == "char" {
+				res.Type = fmt.Sprintf("character(%d)", *length)
+			} else {
+				res.Type = fmt.Sprintf("varchar(%d)", *length)
+			}
+		} else {
+			res.Type = "text"
+		}
+
+	case contains(dataType, textDatatypes):
+		res.Type = "text"
+
+	case dataType == "enum":
+		res.Type = "text"
+		if strict {
+			var values []string
+			for _, v := range c.GetEnumValues() {
+				values = append(values, QuoteLiteral(v))
+			}
+			check := fmt.Sprintf("%s IN (%s)", QuoteIdentifier(c.ColumnName), strings.Join(values, ", "))
+			res.Check = &check
+		}
+
+	case dataType == "set":
+		// sets are loaded as their comma separated mysql representation
+		res.Type = "text"
+
+	case dataType == "json":
+		res.Type = "jsonb"
+
+	case contains(dataType, blobDatatypes):
+		res.Type = "bytea"
+
+	case contains(dataType, spatialDatatypes):
+		// spatial values are loaded as WKT (see mysqlGeometryToWKT)
+		res.Spatial = true
+		if strict {
+			res.Type = "geometry"
+		} else {
+			res.Type = "text"
+		}
+
+	default:
+		if strict {
+			return nil, errors.Errorf("Unsupported mysql type %s for column %s", c.ColumnType, c.ColumnName)
+		}
+		res.Type = "text"
+	}
+
+	return res, nil
+}
+
+// QuoteIdentifier quotes a postgresql identifier (table, column, schema name)
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,117 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"majipoor/lib/mysql"
+	"testing"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestMapIntegers(t *testing.T) {
+	for _, tc := range []struct {
+		dataType   string
+		columnType string
+		loose      string
+		strict     string
+	}{
+		{"tinyint", "tinyint(4)", "smallint", "smallint"},
+		{"tinyint", "tinyint(1)", "smallint", "boolean"},
+		{"tinyint", "tinyint(3) unsigned", "smallint", "smallint"},
+		{"smallint", "smallint(6)", "smallint", "smallint"},
+		{"smallint", "smallint(5) unsigned", "integer", "integer"},
+		{"mediumint", "mediumint(8) unsigned", "integer", "integer"},
+		{"int", "int(11)", "integer", "integer"},
+		{"int", "int(10) unsigned", "bigint", "bigint"},
+		{"bigint", "bigint(20)", "bigint", "bigint"},
+		{"bigint", "bigint(20) unsigned", "numeric(20,0)", "numeric(20,0)"},
+		{"bit", "bit(1)", "smallint", "boolean"},
+		{"bit", "bit(10)", "bigint", "bigint"},
+		{"bit", "bit(64)", "numeric(20,0)", "numeric(20,0)"},
+		{"year", "year(4)", "smallint", "smallint"},
+	} {
+		c := &mysql.ColumnMetadata{ColumnName: "col", DataType: tc.dataType, ColumnType: tc.columnType}
+
+		loose, err := MapColumn(c, TypeMappingLoose)
+		require.Nil(t, err)
+		assert.Equal(t, tc.loose, loose.Type, tc.columnType)
+
+		strict, err := MapColumn(c, TypeMappingStrict)
+		require.Nil(t, err)
+		assert.Equal(t, tc.strict, strict.Type, tc.columnType)
+	}
+}
+
+func TestMapDates(t *testing.T) {
+	c := &mysql.ColumnMetadata{ColumnName: "created_at", DataType: "datetime", ColumnType: "datetime(6)", IsNullable: "NO"}
+	col, err := MapColumn(c, TypeMappingLoose)
+	require.Nil(t, err)
+	assert.Equal(t, "timestamp(6)", col.Type)
+	// zero dates are loaded as NULL in loose mode
+	assert.False(t, col.NotNull)
+
+	col, err = MapColumn(c, TypeMappingStrict)
+	require.Nil(t, err)
+	assert.True(t, col.NotNull)
+
+	c = &mysql.ColumnMetadata{ColumnName: "duration", DataType: "time", ColumnType: "time"}
+	col, err = MapColumn(c, TypeMappingLoose)
+	require.Nil(t, err)
+	assert.Equal(t, "interval", col.Type)
+	col, err = MapColumn(c, TypeMappingStrict)
+	require.Nil(t, err)
+	assert.Equal(t, "time", col.Type)
+}
+
+func TestMapStrings(t *testing.T) {
+	c := &mysql.ColumnMetadata{ColumnName: "name", DataType: "varchar", ColumnType: "varchar(255)",
+		CharacterMaximumLength: intPtr(255)}
+	col, err := MapColumn(c, TypeMappingLoose)
+	require.Nil(t, err)
+	assert.Equal(t, "text", col.Type)
+	col, err = MapColumn(c, TypeMappingStrict)
+	require.Nil(t, err)
+	assert.Equal(t, "varchar(255)", col.Type)
+
+	c = &mysql.ColumnMetadata{ColumnName: "data", DataType: "longblob", ColumnType: "longblob"}
+	col, err = MapColumn(c, TypeMappingLoose)
+	require.Nil(t, err)
+	assert.Equal(t, "bytea", col.Type)
+}
+
+func TestMapEnum(t *testing.T) {
+	c := &mysql.ColumnMetadata{ColumnName: "status", DataType: "enum", ColumnType: "enum('open','it''s closed')",
+		EnumList: strPtr("('open','it''s closed')")}
+	col, err := MapColumn(c, TypeMappingLoose)
+	require.Nil(t, err)
+	assert.Equal(t, "text", col.Type)
+	assert.Nil(t, col.Check)
+
+	col, err = MapColumn(c, TypeMappingStrict)
+	require.Nil(t, err)
+	assert.Equal(t, "text", col.Type)
+	require.NotNil(t, col.Check)
+	assert.Equal(t, `"status" IN ('open', 'it''s closed')`, *col.Check)
+}
+
+func TestMapUnknown(t *testing.T) {
+	c := &mysql.ColumnMetadata{ColumnName: "foo", DataType: "vector", ColumnType: "vector(3)"}
+	col, err := MapColumn(c, TypeMappingLoose)
+	require.Nil(t, err)
+	assert.Equal(t, "text", col.Type)
+
+	_, err = MapColumn(c, TypeMappingStrict)
+	assert.NotNil(t, err)
+
+	c = &mysql.ColumnMetadata{ColumnName: "location", DataType: "point", ColumnType: "point"}
+	col, err = MapColumn(c, TypeMappingStrict)
+	require.Nil(t, err)
+	assert.Equal(t, "geometry", col.Type)
+}
@@ -0,0 +1,175 @@
+package psql
+
+import (
+	"fmt"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+type step struct {
+	statement          string
+	runningDescription string
+	errorDescription   string
+}
+
+// ExecuteStatements runs steps one by one, outside of a transaction (CREATE DATABASE can't run
+// inside one). ${Key} placeholders are replaced by values, which have to be quoted already.
+func (pd *PsqlDB) ExecuteStatements(steps []step, values map[string]string, dryRun bool) error {
+	var replacements []string
+	for k, v := range values {
+		replacements = append(replacements, fmt.Sprintf("${%s}", k))
+		replacements = append(replacements, v)
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	for _, s := range steps {
+		sql_ := replacer.Replace(s.statement)
+
+		if dryRun {
+			log.Info().Str("sql", sql_).Msg(s.runningDescription)
+		} else {
+			_, err := pd.Db.Exec(sql_)
+			if err != nil {
+				return errors.Wrap(err, s.errorDescription)
+			}
+			log.Info().Msg(s.runningDescription)
+		}
+	}
+
+	return nil
+}
+
+func (pd *PsqlDB) exists(description string, sb *sqlbuilder.SelectBuilder, dryRun bool) (bool, error) {
+	sb2 := sqlbuilder.Buildf("SELECT EXISTS(%v)", sb)
+	sql_, args_ := sb2.BuildWithFlavor(sqlbuilder.PostgreSQL)
+	if dryRun {
+		log.Info().Str("sql", sql_).Interface("args", args_).Msgf("Checking if %s exists", description)
+		return false, nil
+	}
+
+	var exists bool
+	err := pd.Db.QueryRow(sql_, args_...).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrapf(err, "Could not check if %s exists", description)
+	}
+	return exists, nil
+}
+
+type CreateReplicaDatabaseSettings struct {
+	Force    bool
+	DryRun   bool
+	Database string
+}
+
+// CreateReplicaDatabase creates the database the replicated schema lives in.
+// It has to be run as a superuser, connected to another database.
+func (pd *PsqlDB) CreateReplicaDatabase(settings CreateReplicaDatabaseSettings) error {
+	if settings.Database == "postgres" {
+		return errors.New("Refusing to create the postgres maintenance database")
+	}
+
+	if settings.Force {
+		sql_ := fmt.Sprintf("DROP DATABASE IF EXISTS %s", QuoteIdentifier(settings.Database))
+		if settings.DryRun {
+			log.Info().Str("sql", sql_).Msg("Force deletion of database")
+		} else {
+			_, err := pd.Db.Exec(sql_)
+			if err != nil {
+				return errors.Wrap(err, "Could not delete database")
+			}
+			log.Info().Str("database", settings.Database).Msg("Deleting database")
+		}
+	} else {
+		sb := sqlbuilder.Select("datname").From("pg_database")
+		sb.Where(sb.Equal("datname", settings.Database))
+		exists, err := pd.exists("database", sb, settings.DryRun)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.Errorf("Database %s already exists", settings.Database)
+		}
+	}
+
+	statements := []step{
+		{"CREATE DATABASE ${Database}", "Creating database", "Could not create database"},
+	}
+	return pd.ExecuteStatements(statements, map[string]string{
+		"Database": QuoteIdentifier(settings.Database),
+	}, settings.DryRun)
+}
+
+// previousUserSuffix is appended to the name of the user replaced by CreateReplicaUser until
+// it is dropped.
+const previousUserSuffix = "__previous"
+
+type CreateReplicaUserSettings struct {
+	Force  bool
+	DryRun bool
+	// RootUsername is the user running the statements. It is never dropped.
+	RootUsername   string
+	Database       string
+	Schema         string
+	MetadataSchema string
+	Username       string
+	Password       string
+}
+
+// CreateReplicaUser creates the user the snapshot and the applier connect as.
+// It has to be run as a superuser, connected to the replica database.
+//
+// The user owns the destination and metadata schemas, and can create schemas in the database,
+// which is needed for the staging and generation schemas of a reload. It gets no other privilege.
+// Tables created in the schemas before keep their owner.
+//
+// With Force, an existing user is replaced, and the objects it owns are handed over to the
+// new user.
+func (pd *PsqlDB) CreateReplicaUser(settings CreateReplicaUserSettings) error {
+	if settings.Username == settings.RootUsername {
+		return errors.Errorf("Refusing to recreate root user %s as the replica user", settings.Username)
+	}
+
+	sb := sqlbuilder.Select("rolname").From("pg_roles")
+	sb.Where(sb.Equal("rolname", settings.Username))
+	exists, err := pd.exists("user", sb, settings.DryRun)
+	if err != nil {
+		return err
+	}
+	if exists && !settings.Force {
+		return errors.Errorf("User %s already exists", settings.Username)
+	}
+	// the previous user is renamed, and hands its objects over to the new one once it exists
+	replace := settings.Force && (exists || settings.DryRun)
+
+	var statements []step
+	if replace {
+		statements = append(statements,
+			step{"ALTER ROLE ${User} RENAME TO ${Previous}", "Renaming previous user", "Could not rename previous user"})
+	}
+	statements = append(statements, []step{
+		{"CREATE ROLE ${User} WITH LOGIN PASSWORD ${Password}", "Creating user", "Could not create user"},
+		{"GRANT CONNECT, CREATE ON DATABASE ${Database} TO ${User}", "Granting database privileges", "Could not grant database privileges"},
+		{"CREATE SCHEMA IF NOT EXISTS ${Schema}", "Creating schema", "Could not create schema"},
+		{"ALTER SCHEMA ${Schema} OWNER TO ${User}", "Granting schema ownership", "Could not grant schema ownership"},
+		{"CREATE SCHEMA IF NOT EXISTS ${MetadataSchema}", "Creating metadata schema", "Could not create metadata schema"},
+		{"ALTER SCHEMA ${MetadataSchema} OWNER TO ${User}", "Granting metadata schema ownership", "Could not grant metadata schema ownership"},
+	}...)
+	if replace {
+		// the tables owned by the previous user, and thus the replicated data, are kept
+		statements = append(statements, []step{
+			{"REASSIGN OWNED BY ${Previous} TO ${User}", "Reassigning objects", "Could not reassign objects"},
+			{"DROP OWNED BY ${Previous}", "Dropping privileges", "Could not drop privileges"},
+			{"DROP ROLE ${Previous}", "Force deletion of previous user", "Could not delete previous user"},
+		}...)
+	}
+	return pd.ExecuteStatements(statements, map[string]string{
+		"User":           QuoteIdentifier(settings.Username),
+		"Previous":       QuoteIdentifier(settings.Username + previousUserSuffix),
+		"Password":       QuoteLiteral(settings.Password),
+		"Database":       QuoteIdentifier(settings.Database),
+		"Schema":         QuoteIdentifier(settings.Schema),
+		"MetadataSchema": QuoteIdentifier(settings.MetadataSchema),
+	}, settings.DryRun)
+}
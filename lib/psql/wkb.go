@@ -0,0 +1,171 @@
+package psql
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Mysql stores spatial values as a 4 byte SRID followed by the WKB of the geometry, which is
+// what the binlog and hex() return. They are loaded as WKT, which PostGIS parses and which
+// stays readable in text columns. The SRID is dropped.
+
+var wkbTypes = map[uint32]string{
+	1: "POINT",
+	2: "LINESTRING",
+	3: "POLYGON",
+	4: "MULTIPOINT",
+	5: "MULTILINESTRING",
+	6: "MULTIPOLYGON",
+	7: "GEOMETRYCOLLECTION",
+}
+
+// mysqlGeometryToWKT returns the WKT of a mysql spatial value.
+func mysqlGeometryToWKT(value []byte) (string, error) {
+	if len(value) < 4 {
+		return "", errors.New("Spatial value without SRID")
+	}
+	r := &wkbReader{data: value[4:]}
+	var sb strings.Builder
+	if err := r.geometry(&sb); err != nil {
+		return "", errors.Wrap(err, "Could not parse WKB")
+	}
+	if len(r.data) > 0 {
+		return "", errors.Errorf("Could not parse WKB: %d trailing bytes", len(r.data))
+	}
+	return sb.String(), nil
+}
+
+// hexGeometryToWKT returns the WKT of the hex of a mysql spatial value.
+func hexGeometryToWKT(value string) (string, error) {
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not decode spatial value")
+	}
+	return mysqlGeometryToWKT(b)
+}
+
+type wkbReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) uint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errors.New("unexpected end of WKB")
+	}
+	v := r.order.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *wkbReader) float64() (float64, error) {
+	if len(r.data) < 8 {
+		return 0, errors.New("unexpected end of WKB")
+	}
+	v := math.Float64frombits(r.order.Uint64(r.data))
+	r.data = r.data[8:]
+	return v, nil
+}
+
+// header reads the byte order and the type of a geometry.
+func (r *wkbReader) header() (uint32, error) {
+	if len(r.data) < 1 {
+		return 0, errors.New("unexpected end of WKB")
+	}
+	switch r.data[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return 0, errors.Errorf("unknown byte order %d", r.data[0])
+	}
+	r.data = r.data[1:]
+	return r.uint32()
+}
+
+func (r *wkbReader) geometry(sb *strings.Builder) error {
+	geometryType, err := r.header()
+	if err != nil {
+		return err
+	}
+	name, ok := wkbTypes[geometryType]
+	if !ok {
+		return errors.Errorf("unknown geometry type %d", geometryType)
+	}
+	sb.WriteString(name)
+
+	switch geometryType {
+	case 1:
+		sb.WriteString("(")
+		if err = r.point(sb); err != nil {
+			return err
+		}
+		sb.WriteString(")")
+		return nil
+	case 2:
+		return r.points(sb)
+	case 3:
+		return r.list(sb, r.points)
+	}
+
+	// the members of collections are geometries with their own header
+	return r.list(sb, func(sb *strings.Builder) error {
+		if geometryType == 7 {
+			return r.geometry(sb)
+		}
+		var member strings.Builder
+		if err := r.geometry(&member); err != nil {
+			return err
+		}
+		// MULTIPOINT((1 2),(3 4)) drops the type of its members
+		sb.WriteString(strings.TrimSpace(strings.TrimLeft(member.String(), "ABCDEFGHIJKLMNOPQRSTUVWXYZ")))
+		return nil
+	})
+}
+
+func (r *wkbReader) point(sb *strings.Builder) error {
+	x, err := r.float64()
+	if err != nil {
+		return err
+	}
+	y, err := r.float64()
+	if err != nil {
+		return err
+	}
+	sb.WriteString(strconv.FormatFloat(x, 'g', -1, 64))
+	sb.WriteString(" ")
+	sb.WriteString(strconv.FormatFloat(y, 'g', -1, 64))
+	return nil
+}
+
+func (r *wkbReader) points(sb *strings.Builder) error {
+	return r.list(sb, r.point)
+}
+
+// list reads a count followed by as many elements, written between parentheses.
+func (r *wkbReader) list(sb *strings.Builder, element func(sb *strings.Builder) error) error {
+	n, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteString("(")
+	for i := uint32(0); i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		if err = element(sb); err != nil {
+			return err
+		}
+	}
+	sb.WriteString(")")
+	return nil
+}
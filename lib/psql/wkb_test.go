@@ -0,0 +1,33 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHexGeometryToWKT(t *testing.T) {
+	for value, expected := range map[string]string{
+		// POINT(1 2), SRID 4326
+		"E6100000" + "0101000000" + "000000000000F03F" + "0000000000000040": "POINT(1 2)",
+		// big endian
+		"00000000" + "0000000001" + "3FF0000000000000" + "4000000000000000": "POINT(1 2)",
+		"00000000" + "0102000000" + "02000000" + "00000000000000000000000000000000" +
+			"000000000000F03F000000000000F03F": "LINESTRING(0 0,1 1)",
+		"00000000" + "0103000000" + "01000000" + "04000000" + "00000000000000000000000000000000" +
+			"000000000000F03F0000000000000000" + "0000000000000000000000000000F03F" +
+			"00000000000000000000000000000000": "POLYGON((0 0,1 0,0 1,0 0))",
+		"00000000" + "0104000000" + "02000000" + "0101000000000000000000F03F0000000000000040" +
+			"01010000000000000000000840000000000000E0BF": "MULTIPOINT((1 2),(3 -0.5))",
+		"00000000" + "0107000000" + "00000000": "GEOMETRYCOLLECTION EMPTY",
+	} {
+		wkt, err := hexGeometryToWKT(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, wkt)
+	}
+
+	_, err := hexGeometryToWKT("00000000" + "0101000000" + "000000000000F03F")
+	assert.Error(t, err)
+	_, err = hexGeometryToWKT("00000000" + "0108000000")
+	assert.Error(t, err)
+}
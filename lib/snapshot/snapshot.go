@@ -0,0 +1,614 @@
+// Package snapshot loads the content of mysql tables into postgresql.
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"majipoor/lib/binlog"
+	"majipoor/lib/mysql"
+	"majipoor/lib/psql"
+	"sync"
+	"time"
+)
+
+type Snapshotter struct {
+	Mysql          *mysql.MysqlDB
+	Psql           *psql.PsqlDB
+	Database       string
+	Schema         string
+	MetadataSchema string
+	Mapping        psql.MappingSettings
+	// ChunkSize is the number of rows loaded per chunk, 0 to load each table in one go
+	ChunkSize int
+	// Workers is the number of chunks loaded in parallel, each on its own mysql connection
+	Workers int
+	// Restart discards the progress of an interrupted snapshot instead of resuming it
+	Restart bool
+}
+
+type TableSnapshotResult struct {
+	Table string
+	Rows  int64
+	// Duration is the time spent loading the chunks of the table, summed over all workers
+	Duration time.Duration
+	Chunks   int
+}
+
+type SnapshotResult struct {
+	// MasterStatus holds the binlog coordinates the snapshot is consistent with
+	MasterStatus mysql.MasterStatus
+	Tables       []*TableSnapshotResult
+	// Resumed is true if the snapshot resumed an interrupted snapshot. Chunks loaded after
+	// the interruption are read at ResumedStatus, more recent than MasterStatus, so the
+	// binlog is replayed from MasterStatus with upserts until ResumedStatus.
+	Resumed       bool
+	ResumedStatus mysql.MasterStatus
+}
+
+// position returns the position the snapshot is consistent with.
+func (s *SnapshotResult) position() binlog.Position {
+	return binlog.Position{
+		GTIDSet: s.MasterStatus.ExecutedGtidSet,
+		File:    s.MasterStatus.File,
+		Pos:     s.MasterStatus.Position,
+	}
+}
+
+// upsertUntil returns the position up to which the changes have to be applied as upserts,
+// nil if the snapshot wasn't resumed.
+func (s *SnapshotResult) upsertUntil() *binlog.Position {
+	if !s.Resumed {
+		return nil
+	}
+	return &binlog.Position{
+		GTIDSet: s.ResumedStatus.ExecutedGtidSet,
+		File:    s.ResumedStatus.File,
+		Pos:     s.ResumedStatus.Position,
+	}
+}
+
+// recordTx records the snapshot, and its position as the position of the tables (see
+// psql.RecordTablesPosition), which resets the replication checkpoint to it if they are all
+// the tables of the schema. It records the columns of the tables as their schema version at
+// that point and deletes the snapshot job into jobSchema.
+func (s *Snapshotter) recordTx(tx *sqlx.Tx, result *SnapshotResult, jobSchema string, tables []*snapshotTable) error {
+	var names []string
+	for _, t := range tables {
+		names = append(names, t.name)
+	}
+	err := psql.RecordSnapshot(tx, s.MetadataSchema, result.record(names))
+	if err != nil {
+		return err
+	}
+	position := result.position()
+	err = psql.RecordTablesPosition(tx, s.MetadataSchema, s.Schema, names, position, result.upsertUntil())
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		err = psql.RecordSchemaVersion(tx, s.MetadataSchema, s.Database, t.name, position, t.columns)
+		if err != nil {
+			return err
+		}
+	}
+	return psql.DeleteSnapshotJob(tx, s.MetadataSchema, jobSchema)
+}
+
+func (s *SnapshotResult) record(tables []string) *psql.SnapshotRecord {
+	return &psql.SnapshotRecord{
+		GtidSet:        s.MasterStatus.ExecutedGtidSet,
+		BinlogFile:     s.MasterStatus.File,
+		BinlogPosition: s.MasterStatus.Position,
+		Tables:         tables,
+	}
+}
+
+type snapshotTable struct {
+	name    string
+	columns []*mysql.ColumnMetadata
+	table   *psql.Table
+	// key is the chunk key of the table, empty if the table can't be chunked
+	key []string
+}
+
+func (s *Snapshotter) getTables(tables []string) ([]*snapshotTable, error) {
+	var ret []*snapshotTable
+	for _, tableName := range tables {
+		columns, err := s.Mysql.GetColumnMetadata(s.Database, tableName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not get column metadata for %s", tableName)
+		}
+		metadata, err := s.Mysql.GetTableMetadata(s.Database, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !metadata.IsTransactional() {
+			engine := ""
+			if metadata.Engine != nil {
+				engine = *metadata.Engine
+			}
+			log.Warn().Str("table", tableName).Str("engine", engine).
+				Msg("Non transactional table, its rows may not match the snapshot binlog position")
+		}
+		table, err := psql.MapTable(tableName, columns, s.Mapping)
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := s.Mysql.GetIndexes(s.Database, tableName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not get indexes of %s", tableName)
+		}
+		if s.Mapping.Indexes {
+			table.MapIndexes(indexes)
+		}
+		var key []string
+		if index := mysql.GetChunkKey(indexes); index != nil {
+			key = index.Columns
+		} else {
+			log.Warn().Str("table", tableName).Msg("No primary key or unique index without NULLs, the table will be loaded in one chunk")
+		}
+		ret = append(ret, &snapshotTable{name: tableName, columns: columns, table: table, key: key})
+	}
+	return ret, nil
+}
+
+// planChunks splits every table into chunks, as seen by cs. It only queries through cs,
+// as the other connections of the pool can all be held by the workers.
+func (s *Snapshotter) planChunks(cs *mysql.ConsistentSnapshot, schema string, tables []*snapshotTable) ([]*psql.SnapshotChunk, error) {
+	var chunks []*psql.SnapshotChunk
+	for _, t := range tables {
+		tableChunks, err := cs.GetChunks(t.name, t.columns, t.key, s.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Str("table", t.name).Strs("key", t.key).Int("chunks", len(tableChunks)).Msg("Planned chunks")
+		for i, c := range tableChunks {
+			chunks = append(chunks, &psql.SnapshotChunk{
+				Schema:     schema,
+				Table:      t.name,
+				Chunk:      i,
+				Key:        t.key,
+				LowerBound: c.Lower,
+				UpperBound: c.Upper,
+			})
+		}
+	}
+	return chunks, nil
+}
+
+// loadChunk copies the rows of chunk into schema, and marks it as done in the same transaction.
+func (s *Snapshotter) loadChunk(cs *mysql.ConsistentSnapshot, schema string, t *snapshotTable, chunk *psql.SnapshotChunk,
+	metadata *psql.RowMetadata) error {
+	start := time.Now()
+
+	tx, err := s.Psql.Db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	sql_, args, err := mysql.GetChunkSelectStatement(t.name, t.columns, chunk.Key,
+		&mysql.Chunk{Lower: chunk.LowerBound, Upper: chunk.UpperBound})
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("table", t.name).Int("chunk", chunk.Chunk).Str("sql", sql_).Msg("Selecting rows")
+	rows, err := cs.Query(sql_, args...)
+	if err != nil {
+		return errors.Wrapf(err, "Could not select rows from %s", t.name)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	count, err := psql.CopyRows(tx, schema, t.table, rows, metadata)
+	if err != nil {
+		return err
+	}
+
+	done := *chunk
+	done.Rows = count
+	done.Duration = time.Since(start)
+	err = psql.CompleteSnapshotChunk(tx, s.MetadataSchema, &done)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "Could not commit chunk %d of %s", chunk.Chunk, t.name)
+	}
+	*chunk = done
+	chunk.Done = true
+
+	log.Info().Str("table", t.name).Int("chunk", chunk.Chunk).
+		Int64("rows", chunk.Rows).
+		Dur("duration", chunk.Duration).
+		Msg("Loaded chunk")
+
+	return nil
+}
+
+// loadChunks loads chunks in parallel, one worker per snapshot, stopping at the first error.
+func (s *Snapshotter) loadChunks(snapshots []*mysql.ConsistentSnapshot, schema string, tables map[string]*snapshotTable,
+	chunks []*psql.SnapshotChunk, metadata *psql.RowMetadata) error {
+	pending := make(chan *psql.SnapshotChunk)
+	// buffered so that failing workers never block
+	errs := make(chan error, len(snapshots))
+
+	var wg sync.WaitGroup
+	for _, cs := range snapshots {
+		wg.Add(1)
+		go func(cs *mysql.ConsistentSnapshot) {
+			defer wg.Done()
+			for chunk := range pending {
+				err := s.loadChunk(cs, schema, tables[chunk.Table], chunk, metadata)
+				if err != nil {
+					errs <- errors.Wrapf(err, "Could not load chunk %d of %s", chunk.Chunk, chunk.Table)
+					return
+				}
+			}
+		}(cs)
+	}
+
+	var err error
+dispatch:
+	for _, chunk := range chunks {
+		if chunk.Done {
+			continue
+		}
+		select {
+		case pending <- chunk:
+		case err = <-errs:
+			break dispatch
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
+
+// run loads tables into schema in chunks, resuming the snapshot job into schema if there is one.
+// prepare is called before starting a new job.
+// The returned result has not been recorded yet, see Snapshotter.recordTx.
+func (s *Snapshotter) run(schema string, tables []*snapshotTable, truncate bool, prepare func() error) (*SnapshotResult, error) {
+	err := s.Psql.CreateMetadataTables(s.MetadataSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	tablesByName := map[string]*snapshotTable{}
+	for _, t := range tables {
+		names = append(names, t.name)
+		tablesByName[t.name] = t
+	}
+
+	job, err := s.Psql.GetSnapshotJob(s.MetadataSchema, schema)
+	if err != nil {
+		return nil, err
+	}
+	if job != nil && (s.Restart || !sameTables(job.Tables, names)) {
+		if !s.Restart {
+			log.Warn().Strs("tables", job.Tables).Str("schema", schema).
+				Msg("Discarding interrupted snapshot of other tables")
+		}
+		err = psql.DeleteSnapshotJob(s.Psql.Db, s.MetadataSchema, schema)
+		if err != nil {
+			return nil, err
+		}
+		job = nil
+	}
+	if job != nil {
+		table, err := s.unresumableTable(schema, tablesByName)
+		if err != nil {
+			return nil, err
+		}
+		if table != "" {
+			log.Warn().Str("table", table).Str("schema", schema).
+				Msg("Restarting interrupted snapshot, a table without upserts has chunks left")
+			err = psql.DeleteSnapshotJob(s.Psql.Db, s.MetadataSchema, schema)
+			if err != nil {
+				return nil, err
+			}
+			job = nil
+		}
+	}
+
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	snapshots, err := s.Mysql.StartConsistentSnapshots(context.Background(), workers)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not start consistent snapshot")
+	}
+	defer func() {
+		for _, cs := range snapshots {
+			_ = cs.Close()
+		}
+	}()
+
+	result := &SnapshotResult{}
+	if job == nil {
+		job, err = s.startJob(snapshots[0], schema, tables, truncate, prepare)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		result.Resumed = true
+		result.ResumedStatus = snapshots[0].MasterStatus
+		log.Warn().Str("schema", schema).Str("gtid-set", job.GtidSet).Time("started-at", job.StartedAt).
+			Str("resumed-gtid-set", result.ResumedStatus.ExecutedGtidSet).
+			Msg("Resuming interrupted snapshot, the binlog is applied with upserts up to the resumed position")
+	}
+	result.MasterStatus = mysql.MasterStatus{
+		File:            job.BinlogFile,
+		Position:        job.BinlogPosition,
+		ExecutedGtidSet: job.GtidSet,
+	}
+
+	chunks, err := s.Psql.GetSnapshotChunks(s.MetadataSchema, schema)
+	if err != nil {
+		return nil, err
+	}
+	// the chunks are read at the position of the current consistent snapshot, later than the
+	// job's if it is resumed
+	metadata := &psql.RowMetadata{GtidSet: job.GtidSet, CommitTime: job.StartedAt}
+	if result.Resumed {
+		metadata = &psql.RowMetadata{GtidSet: result.ResumedStatus.ExecutedGtidSet, CommitTime: time.Now()}
+	}
+	err = s.loadChunks(snapshots, schema, tablesByName, chunks, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]*TableSnapshotResult{}
+	for _, c := range chunks {
+		r, ok := results[c.Table]
+		if !ok {
+			r = &TableSnapshotResult{Table: c.Table}
+			results[c.Table] = r
+		}
+		r.Rows += c.Rows
+		r.Duration += c.Duration
+		r.Chunks++
+	}
+	for _, name := range names {
+		if r, ok := results[name]; ok {
+			log.Info().Str("table", name).
+				Int64("rows", r.Rows).
+				Int("chunks", r.Chunks).
+				Dur("duration", r.Duration).
+				Msg("Snapshotted table")
+			result.Tables = append(result.Tables, r)
+		}
+	}
+
+	return result, nil
+}
+
+// unresumableTable returns a table with chunks left to load that can't be upserted, or "" if
+// there is none. The chunks of a resumed job are read at a later position than the job, and
+// the changes they already hold are applied again when replaying the binlog from the job.
+func (s *Snapshotter) unresumableTable(schema string, tablesByName map[string]*snapshotTable) (string, error) {
+	chunks, err := s.Psql.GetSnapshotChunks(s.MetadataSchema, schema)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range chunks {
+		if t, ok := tablesByName[c.Table]; ok && !c.Done && !t.table.CanUpsert() {
+			return c.Table, nil
+		}
+	}
+	return "", nil
+}
+
+// startJob plans the chunks of a new snapshot job and records them. If truncate is set,
+// the tables are truncated in the same transaction.
+func (s *Snapshotter) startJob(cs *mysql.ConsistentSnapshot, schema string, tables []*snapshotTable, truncate bool, prepare func() error) (*psql.SnapshotJob, error) {
+	if prepare != nil {
+		err := prepare()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunks, err := s.planChunks(cs, schema, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &psql.SnapshotJob{
+		Schema:         schema,
+		GtidSet:        cs.MasterStatus.ExecutedGtidSet,
+		BinlogFile:     cs.MasterStatus.File,
+		BinlogPosition: cs.MasterStatus.Position,
+		StartedAt:      time.Now(),
+	}
+	for _, t := range tables {
+		job.Tables = append(job.Tables, t.name)
+	}
+
+	tx, err := s.Psql.Db.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if truncate {
+		for _, t := range tables {
+			_, err = tx.Exec(fmt.Sprintf("TRUNCATE %s", psql.QuoteTableName(schema, t.table.Name)))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Could not truncate %s.%s", schema, t.table.Name)
+			}
+		}
+	}
+	err = psql.SaveSnapshotJob(tx, s.MetadataSchema, job, chunks)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not commit snapshot job")
+	}
+
+	return job, nil
+}
+
+func sameTables(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, t := range a {
+		seen[t] = true
+	}
+	for _, t := range b {
+		if !seen[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot loads the tables directly into the destination schema, stopping at the
+// first error. The tables are truncated, and then loaded in chunks, all read from the same
+// consistent snapshot, whose binlog coordinates are recorded once every table has been loaded,
+// and become the new replication checkpoint, or the position of the tables if they are not all
+// the tables of the schema.
+//
+// The tables are incomplete while they are loading, see Reload to avoid that.
+func (s *Snapshotter) Snapshot(tables []string) (*SnapshotResult, error) {
+	snapshotTables, err := s.getTables(tables)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.run(s.Schema, snapshotTables, true, nil)
+	if err != nil {
+		return result, err
+	}
+
+	tx, err := s.Psql.Db.Beginx()
+	if err != nil {
+		return result, errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	err = s.recordTx(tx, result, s.Schema, snapshotTables)
+	if err != nil {
+		return result, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return result, errors.Wrap(err, "Could not commit snapshot record")
+	}
+
+	return result, nil
+}
+
+type ReloadSettings struct {
+	StagingSchema string
+	// KeepGenerationsFor is how long replaced tables are kept around for a rollback
+	KeepGenerationsFor time.Duration
+}
+
+// Reload loads the tables into a fresh staging schema, and then swaps them into the
+// destination schema in a single transaction, so that readers never see a half loaded table.
+// The replaced tables are kept in a generation schema (see psql.SwapTables).
+// The binlog coordinates of the snapshot are recorded, as in Snapshot, in the same transaction
+// as the swap.
+//
+// An interrupted reload resumes from the chunks already loaded into the staging schema.
+func (s *Snapshotter) Reload(tables []string, settings ReloadSettings) (*SnapshotResult, error) {
+	// fail before loading rather than at the swap
+	if err := psql.CheckSwappable(s.Psql.Db, s.Schema, tables); err != nil {
+		return nil, err
+	}
+	snapshotTables, err := s.getTables(tables)
+	if err != nil {
+		return nil, err
+	}
+	var psqlTables []*psql.Table
+	for _, t := range snapshotTables {
+		psqlTables = append(psqlTables, t.table)
+	}
+
+	prepare := func() error {
+		err := s.Psql.RecreateSchema(settings.StagingSchema)
+		if err != nil {
+			return err
+		}
+		err = s.Psql.CreateSchema(psql.CreateSchemaSettings{
+			Force:        true,
+			Schema:       settings.StagingSchema,
+			Tables:       psqlTables,
+			DeferIndexes: true,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Could not create staging tables")
+		}
+		return nil
+	}
+
+	result, err := s.run(settings.StagingSchema, snapshotTables, false, prepare)
+	if err != nil {
+		return result, err
+	}
+	// the indexes are built once, after loading the rows
+	err = s.Psql.CreateIndexes(settings.StagingSchema, psqlTables)
+	if err != nil {
+		return result, err
+	}
+
+	tx, err := s.Psql.Db.Beginx()
+	if err != nil {
+		return result, errors.Wrap(err, "Could not start transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	backupSchema := psql.GetGenerationSchemaName(s.Schema, time.Now())
+	// the replaced tables keep their position, to be restored by a rollback
+	err = psql.RecordGenerationPositions(tx, s.MetadataSchema, backupSchema, tables)
+	if err != nil {
+		return result, err
+	}
+	err = psql.SwapTablesTx(tx, settings.StagingSchema, s.Schema, backupSchema, tables)
+	if err != nil {
+		return result, err
+	}
+	err = s.recordTx(tx, result, settings.StagingSchema, snapshotTables)
+	if err != nil {
+		return result, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return result, errors.Wrap(err, "Could not commit swap")
+	}
+	log.Info().Str("from", settings.StagingSchema).Str("to", s.Schema).Str("backup", backupSchema).
+		Int("tables", len(tables)).Msg("Swapped tables")
+
+	err = s.Psql.DropExpiredGenerations(s.Schema, s.MetadataSchema, settings.KeepGenerationsFor)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}